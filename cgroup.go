@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const defaultCgroupRoot = "/sys/fs/cgroup"
+
+// Layouts of the cgroup file system.
+const (
+	// cgroupV1 mounts one hierarchy per controller group under /sys/fs/cgroup.
+	cgroupV1 = "v1"
+	// cgroupHybrid adds the controller-less v2 hierarchy at /sys/fs/cgroup/unified to the v1 hierarchies.
+	cgroupHybrid = "hybrid"
+	// cgroupV2 mounts the single unified hierarchy at /sys/fs/cgroup.
+	cgroupV2 = "v2"
+)
+
+// detectCgroupMode tells the layout of the cgroup file system mounted at root.
+func detectCgroupMode(root string) string {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return cgroupV2
+	}
+	if _, err := os.Stat(filepath.Join(root, "unified", "cgroup.controllers")); err == nil {
+		return cgroupHybrid
+	}
+	return cgroupV1
+}
+
+// cgroupEntry is one hierarchy-ID:controller-list:cgroup-path line of /proc/<pid>/cgroup.
+type cgroupEntry struct {
+	HierarchyID int
+	// Controllers is empty for the v2 hierarchy. Named v1 hierarchies such as name=systemd
+	// are listed with their name= prefix.
+	Controllers []string
+	Path        string
+}
+
+// procCgroup is the cgroup membership of a process in every mounted hierarchy.
+type procCgroup struct {
+	Entries []cgroupEntry
+}
+
+// parseProcCgroup parses the contents of /proc/<pid>/cgroup. Paths may contain colons, so
+// only the first two separate fields.
+func parseProcCgroup(r io.Reader) (procCgroup, error) {
+	var cg procCgroup
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			return cg, fmt.Errorf("invalid cgroup line %q", line)
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return cg, fmt.Errorf("invalid hierarchy ID in cgroup line %q: %w", line, err)
+		}
+		entry := cgroupEntry{HierarchyID: id, Path: fields[2]}
+		if fields[1] != "" {
+			entry.Controllers = strings.Split(fields[1], ",")
+		}
+		cg.Entries = append(cg.Entries, entry)
+	}
+	return cg, scanner.Err()
+}
+
+// readProcCgroup reads <procRoot>/<pid>/cgroup.
+func readProcCgroup(procRoot string, pid int) (procCgroup, error) {
+	file, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return procCgroup{}, err
+	}
+	defer file.Close()
+	return parseProcCgroup(file)
+}
+
+// Unified returns the path in the v2 hierarchy, which exists on hybrid and pure v2 systems.
+func (c procCgroup) Unified() (string, bool) {
+	for _, entry := range c.Entries {
+		if entry.HierarchyID == 0 && len(entry.Controllers) == 0 {
+			return entry.Path, true
+		}
+	}
+	return "", false
+}
+
+// Controller returns the path in the v1 hierarchy the controller, e.g. memory or name=systemd, is attached to.
+func (c procCgroup) Controller(name string) (string, bool) {
+	for _, entry := range c.Entries {
+		if slices.Contains(entry.Controllers, name) {
+			return entry.Path, true
+		}
+	}
+	return "", false
+}
+
+// Path returns the path that best identifies the cgroup of the process: the v2 path, else
+// the systemd v1 hierarchy, else the first v1 controller the process is not at the root of.
+// On hybrid systems the v2 path is often just "/" while the v1 hierarchies are populated.
+func (c procCgroup) Path() string {
+	if path, ok := c.Unified(); ok && path != "/" {
+		return path
+	}
+	if path, ok := c.Controller("name=systemd"); ok && path != "/" {
+		return path
+	}
+	for _, name := range []string{"memory", "cpu", "pids"} {
+		if path, ok := c.Controller(name); ok && path != "/" {
+			return path
+		}
+	}
+	for _, entry := range c.Entries {
+		if entry.Path != "/" {
+			return entry.Path
+		}
+	}
+	return "/"
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testDockerID     = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testPodmanID     = "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	testContainerdID = "1111111111111111111111111111111111111111111111111111111111111111"
+)
+
+// The fixtures under testdata/proc are /proc/<pid>/cgroup files of the cgroup layouts.
+func TestReadProcCgroup(t *testing.T) {
+	tests := []struct {
+		name       string
+		pid        int
+		entries    int
+		unified    string
+		hasUnified bool
+		memory     string
+		hasMemory  bool
+		path       string
+		container  containerRef
+	}{
+		{
+			name:      "v1 cgroupfs driver",
+			pid:       100,
+			entries:   5,
+			memory:    "/docker/" + testDockerID,
+			hasMemory: true,
+			path:      "/docker/" + testDockerID,
+			container: containerRef{Runtime: runtimeDocker, ID: testDockerID},
+		},
+		{
+			name:       "hybrid with an empty v2 hierarchy",
+			pid:        200,
+			entries:    5,
+			unified:    "/",
+			hasUnified: true,
+			memory:     "/system.slice/docker-" + testDockerID + ".scope",
+			hasMemory:  true,
+			path:       "/system.slice/docker-" + testDockerID + ".scope",
+			container:  containerRef{Runtime: runtimeDocker, ID: testDockerID},
+		},
+		{
+			name:       "v2 kubernetes",
+			pid:        300,
+			entries:    1,
+			unified:    "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod7a3c2a4e_1b2c_4d5e_8f90_123456789abc.slice/cri-containerd-" + testContainerdID + ".scope",
+			hasUnified: true,
+			path:       "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod7a3c2a4e_1b2c_4d5e_8f90_123456789abc.slice/cri-containerd-" + testContainerdID + ".scope",
+			container:  containerRef{Runtime: runtimeContainerd, ID: testContainerdID},
+		},
+		{
+			name:       "v2 init scope",
+			pid:        1,
+			entries:    1,
+			unified:    "/init.scope",
+			hasUnified: true,
+			path:       "/init.scope",
+		},
+		{
+			name:       "v1 path with a colon",
+			pid:        400,
+			entries:    3,
+			unified:    "/",
+			hasUnified: true,
+			memory:     "/lxc/web:8080",
+			hasMemory:  true,
+			path:       "/lxc/web:8080",
+			container:  containerRef{Runtime: runtimeLXC, ID: "web:8080"},
+		},
+		{
+			name:       "v2 path with a colon below a container",
+			pid:        500,
+			entries:    1,
+			unified:    "/user.slice/user-1000.slice/user@1000.service/app.slice/app-podman.slice/libpod-" + testPodmanID + ".scope/container:init",
+			hasUnified: true,
+			path:       "/user.slice/user-1000.slice/user@1000.service/app.slice/app-podman.slice/libpod-" + testPodmanID + ".scope/container:init",
+			container:  containerRef{Runtime: runtimePodman, ID: testPodmanID},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cg, err := readProcCgroup("testdata/proc", tt.pid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cg.Entries) != tt.entries {
+				t.Errorf("entries = %d, want %d", len(cg.Entries), tt.entries)
+			}
+			if unified, ok := cg.Unified(); unified != tt.unified || ok != tt.hasUnified {
+				t.Errorf("Unified() = %q, %v, want %q, %v", unified, ok, tt.unified, tt.hasUnified)
+			}
+			if memory, ok := cg.Controller("memory"); memory != tt.memory || ok != tt.hasMemory {
+				t.Errorf("Controller(memory) = %q, %v, want %q, %v", memory, ok, tt.memory, tt.hasMemory)
+			}
+			if path := cg.Path(); path != tt.path {
+				t.Errorf("Path() = %q, want %q", path, tt.path)
+			}
+			container, ok := classifyCgroupPath(cg.Path())
+			if container != tt.container || ok != (tt.container != containerRef{}) {
+				t.Errorf("classifyCgroupPath() = %+v, %v, want %+v", container, ok, tt.container)
+			}
+		})
+	}
+}
+
+func TestReadProcCgroupControllers(t *testing.T) {
+	cg, err := readProcCgroup("testdata/proc", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cpu", "cpuacct", "name=systemd"} {
+		if path, ok := cg.Controller(name); !ok || path != "/docker/"+testDockerID {
+			t.Errorf("Controller(%s) = %q, %v", name, path, ok)
+		}
+	}
+	if _, ok := cg.Controller("blkio"); ok {
+		t.Error("Controller(blkio) found a hierarchy that is not mounted")
+	}
+}
+
+func TestReadProcCgroupErrors(t *testing.T) {
+	if _, err := readProcCgroup("testdata/proc", 600); err == nil || !strings.Contains(err.Error(), "invalid cgroup line") {
+		t.Errorf("malformed line: err = %v", err)
+	}
+	if _, err := readProcCgroup("testdata/proc", 999); err == nil {
+		t.Error("missing process: no error")
+	}
+	if _, err := parseProcCgroup(strings.NewReader("x::/\n")); err == nil || !strings.Contains(err.Error(), "invalid hierarchy ID") {
+		t.Errorf("bad hierarchy ID: err = %v", err)
+	}
+}
+
+// Short and unusual paths must not index out of range.
+func TestClassifyCgroupPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want containerRef
+	}{
+		{"", containerRef{}},
+		{"/", containerRef{}},
+		{"/init.scope", containerRef{}},
+		{"/docker", containerRef{}},
+		{"/docker/", containerRef{}},
+		{"/docker/abc", containerRef{}},
+		{"/lxc", containerRef{}},
+		{"/lxc.payload.", containerRef{}},
+		{"//", containerRef{}},
+		{"/docker/" + testDockerID, containerRef{Runtime: runtimeDocker, ID: testDockerID}},
+		{"/system.slice/crio-conmon-" + testDockerID + ".scope", containerRef{}},
+		{"/system.slice/crio-" + testDockerID + ".scope", containerRef{Runtime: runtimeCRIO, ID: testDockerID}},
+		{"/lxc.payload.web", containerRef{Runtime: runtimeLXC, ID: "web"}},
+		{"/libpod_parent/libpod-" + testPodmanID, containerRef{Runtime: runtimePodman, ID: testPodmanID}},
+	}
+	for _, tt := range tests {
+		got, ok := classifyCgroupPath(tt.path)
+		if got != tt.want || ok != (tt.want != containerRef{}) {
+			t.Errorf("classifyCgroupPath(%q) = %+v, %v, want %+v", tt.path, got, ok, tt.want)
+		}
+	}
+}
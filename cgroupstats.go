@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PSI is the total time tasks of a cgroup were stalled on a resource, from its <resource>.pressure file.
+type PSI struct {
+	// SomeSeconds is the time at least one task was stalled.
+	SomeSeconds float64
+	// FullSeconds is the time all non-idle tasks were stalled at once. CPU only reports it on newer kernels.
+	FullSeconds float64
+	HasFull     bool
+}
+
+// CgroupStats is the resource usage of one cgroup. Each Has* flag tells whether the
+// controller providing the values is enabled for the cgroup.
+type CgroupStats struct {
+	Path string
+	// Unit, Slice and User are derived from the systemd names in the path, see systemdUnitOf.
+	Unit  string
+	Slice string
+	User  string
+
+	CPUSeconds float64
+	HasCPU     bool
+
+	MemoryBytes     uint64
+	HasMemory       bool
+	MemoryPeakBytes uint64
+	HasMemoryPeak   bool
+
+	IOReadBytes  uint64
+	IOWriteBytes uint64
+	HasIO        bool
+
+	PIDs    uint64
+	HasPIDs bool
+
+	// Pressure maps cpu, memory and io to their stall times. PSI only exists on cgroup v2.
+	Pressure map[string]PSI
+}
+
+// systemdUnitOf derives the systemd unit, the innermost slice and, for user-<uid>.slice and
+// user@<uid>.service, the owning user from the components of a cgroup path, deepest first
+// like classifyCgroupPath.
+func systemdUnitOf(cgroupPath string) (unit, slice, user string) {
+	components := strings.Split(strings.Trim(cgroupPath, "/"), "/")
+	for i := len(components) - 1; i >= 0; i-- {
+		component := components[i]
+		switch filepath.Ext(component) {
+		case ".service", ".scope", ".socket", ".mount", ".swap":
+			if unit == "" {
+				unit = component
+			}
+		case ".slice":
+			if slice == "" {
+				slice = component
+			}
+		}
+		if user != "" {
+			continue
+		}
+		uid, ok := strings.CutPrefix(strings.TrimSuffix(component, ".slice"), "user-")
+		if !ok {
+			uid, ok = strings.CutPrefix(strings.TrimSuffix(component, ".service"), "user@")
+		}
+		if n, err := strconv.Atoi(uid); ok && err == nil {
+			user = lookupUserName(n)
+		}
+	}
+	return unit, slice, user
+}
+
+// readCgroupStats walks the cgroup file system at root down to maxDepth levels below the
+// root cgroup and reads the usage of every cgroup it finds. On v1 and hybrid systems the
+// values come from the cpuacct, memory, blkio and pids hierarchies, on v2 from the
+// unified hierarchy.
+func readCgroupStats(root string, maxDepth int) ([]CgroupStats, error) {
+	if detectCgroupMode(root) == cgroupV2 {
+		paths, err := walkCgroups(root, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		stats := make([]CgroupStats, 0, len(paths))
+		for _, path := range paths {
+			stats = append(stats, readCgroupV2Stats(root, path))
+		}
+		return stats, nil
+	}
+
+	controllers := []string{"cpuacct", "memory", "blkio", "pids"}
+	seen := map[string]bool{}
+	var paths []string
+	for _, controller := range controllers {
+		hierarchy, err := filepath.EvalSymlinks(filepath.Join(root, controller))
+		if err != nil {
+			continue
+		}
+		controllerPaths, err := walkCgroups(hierarchy, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range controllerPaths {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	stats := make([]CgroupStats, 0, len(paths))
+	for _, path := range paths {
+		stats = append(stats, readCgroupV1Stats(root, path))
+	}
+	return stats, nil
+}
+
+// walkCgroups returns the paths of the cgroups below hierarchy, relative to it and starting
+// with a slash like in /proc/<pid>/cgroup. The root cgroup itself is left out.
+func walkCgroups(hierarchy string, maxDepth int) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(hierarchy, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// A cgroup removed during the walk is not an error
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() || path == hierarchy {
+			return nil
+		}
+		rel, err := filepath.Rel(hierarchy, path)
+		if err != nil {
+			return err
+		}
+		if strings.Count(rel, string(filepath.Separator))+1 > maxDepth {
+			return fs.SkipDir
+		}
+		paths = append(paths, "/"+filepath.ToSlash(rel))
+		return nil
+	})
+	return paths, err
+}
+
+func newCgroupStats(cgroupPath string) CgroupStats {
+	stats := CgroupStats{Path: cgroupPath}
+	stats.Unit, stats.Slice, stats.User = systemdUnitOf(cgroupPath)
+	return stats
+}
+
+func readCgroupV2Stats(root, cgroupPath string) CgroupStats {
+	stats := newCgroupStats(cgroupPath)
+	dir := filepath.Join(root, cgroupPath)
+	if data, err := os.ReadFile(filepath.Join(dir, "cpu.stat")); err == nil {
+		if usec, ok := parseKeyValues(data)["usage_usec"]; ok {
+			stats.CPUSeconds = float64(usec) / 1e6
+			stats.HasCPU = true
+		}
+	}
+	stats.MemoryBytes, stats.HasMemory = readUintFile(filepath.Join(dir, "memory.current"))
+	stats.MemoryPeakBytes, stats.HasMemoryPeak = readUintFile(filepath.Join(dir, "memory.peak"))
+	if data, err := os.ReadFile(filepath.Join(dir, "io.stat")); err == nil {
+		stats.IOReadBytes, stats.IOWriteBytes = parseIOStat(data)
+		stats.HasIO = true
+	}
+	stats.PIDs, stats.HasPIDs = readUintFile(filepath.Join(dir, "pids.current"))
+	for _, resource := range []string{"cpu", "memory", "io"} {
+		data, err := os.ReadFile(filepath.Join(dir, resource+".pressure"))
+		if err != nil {
+			continue
+		}
+		if psi, err := parsePSI(data); err == nil {
+			if stats.Pressure == nil {
+				stats.Pressure = map[string]PSI{}
+			}
+			stats.Pressure[resource] = psi
+		}
+	}
+	return stats
+}
+
+func readCgroupV1Stats(root, cgroupPath string) CgroupStats {
+	stats := newCgroupStats(cgroupPath)
+	if ns, ok := readUintFile(filepath.Join(root, "cpuacct", cgroupPath, "cpuacct.usage")); ok {
+		stats.CPUSeconds = float64(ns) / 1e9
+		stats.HasCPU = true
+	}
+	memory := filepath.Join(root, "memory", cgroupPath)
+	stats.MemoryBytes, stats.HasMemory = readUintFile(filepath.Join(memory, "memory.usage_in_bytes"))
+	stats.MemoryPeakBytes, stats.HasMemoryPeak = readUintFile(filepath.Join(memory, "memory.max_usage_in_bytes"))
+	if data, err := os.ReadFile(filepath.Join(root, "blkio", cgroupPath, "blkio.throttle.io_service_bytes")); err == nil {
+		stats.IOReadBytes, stats.IOWriteBytes = parseBlkioServiceBytes(data)
+		stats.HasIO = true
+	}
+	stats.PIDs, stats.HasPIDs = readUintFile(filepath.Join(root, "pids", cgroupPath, "pids.current"))
+	return stats
+}
+
+// readUintFile reads a cgroup file holding a single number.
+func readUintFile(path string) (uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return v, err == nil
+}
+
+// parseKeyValues parses the "key value" lines of files such as cpu.stat.
+func parseKeyValues(data []byte) map[string]uint64 {
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+			values[key] = v
+		}
+	}
+	return values
+}
+
+// parseIOStat sums the rbytes and wbytes of every device in a v2 io.stat file.
+func parseIOStat(data []byte) (read, written uint64) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// 8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+		fields := strings.Fields(scanner.Text())
+		for _, field := range fields[min(1, len(fields)):] {
+			key, value, _ := strings.Cut(field, "=")
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				read += v
+			case "wbytes":
+				written += v
+			}
+		}
+	}
+	return read, written
+}
+
+// parseBlkioServiceBytes sums the Read and Write bytes of every device in a v1 blkio.throttle.io_service_bytes file.
+func parseBlkioServiceBytes(data []byte) (read, written uint64) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// 8:0 Read 1459200, and a final "Total 1459200" line
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			read += v
+		case "Write":
+			written += v
+		}
+	}
+	return read, written
+}
+
+// parsePSI parses the totals of a pressure file:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=12345
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=6789
+func parsePSI(data []byte) (PSI, error) {
+	var psi PSI
+	hasSome := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			value, ok := strings.CutPrefix(field, "total=")
+			if !ok {
+				continue
+			}
+			usec, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return psi, fmt.Errorf("invalid pressure total %q: %w", value, err)
+			}
+			switch fields[0] {
+			case "some":
+				psi.SomeSeconds = float64(usec) / 1e6
+				hasSome = true
+			case "full":
+				psi.FullSeconds = float64(usec) / 1e6
+				psi.HasFull = true
+			}
+		}
+	}
+	if !hasSome {
+		return psi, fmt.Errorf("no pressure totals")
+	}
+	return psi, scanner.Err()
+}
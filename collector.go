@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ProcessSample is a process together with the values derived for it during a collection.
+type ProcessSample struct {
+	Process
+	// IORate is only valid when HasIORate is set, i.e. the process was also seen in the previous collection.
+	IORate    IORate
+	HasIORate bool
+	// Delta is what the process used since the previous collection, see usageDeltaTracker.
+	Delta            UsageDelta
+	ContainerID      string
+	ContainerName    string
+	ContainerImage   string
+	ContainerRuntime string
+	// Cgroup is the cgroup path that identifies the process, see procCgroup.Path.
+	Cgroup string
+	// Pod is only set for processes running in a Kubernetes pod.
+	Pod *kubernetesPod
+}
+
+// Snapshot is everything gathered in one collection cycle. Every sink renders the
+// same snapshot, so the Prometheus and InfluxDB outputs always agree.
+type Snapshot struct {
+	Time time.Time
+	// Duration is how long the collection took.
+	Duration  time.Duration
+	Hostname  string
+	OS        string
+	OSVersion string
+	Sessions  []Session
+	Processes []ProcessSample
+	Cgroups   []CgroupStats
+	// Lifecycle counts the logins and logouts seen since the exporter started.
+	Lifecycle SessionLifecycle
+	// Logind is what systemd-logind reported, nil when it is not collected or could not be asked.
+	Logind *LogindState
+	// FailedLogins counts the failed login attempts seen since the exporter started.
+	FailedLogins []FailedLogin
+	// Violations are the results of the session rules.
+	Violations []PolicyViolation
+	// Users sums Processes and Sessions by user.
+	Users []UserUsage
+	// Groups sums the processes matched by the group rules by group.
+	Groups []GroupUsage
+}
+
+// Sink consumes snapshots produced by a Collector.
+type Sink interface {
+	Name() string
+	Write(snapshot *Snapshot) error
+}
+
+// collectorOptions selects what a Collector gathers.
+type collectorOptions struct {
+	Collectors   CollectorsConfig
+	Filters      FiltersConfig
+	Runtimes     RuntimesConfig
+	Cgroups      CgroupsConfig
+	Groups       []GroupConfig
+	Sessions     SessionsConfig
+	FailedLogins FailedLoginsConfig
+	Logind       LogindConfig
+	Rules        []RuleConfig
+}
+
+// Collector gathers sessions and processes into a Snapshot.
+type Collector struct {
+	osDist    string
+	osVersion string
+	ioRates   *ioRateTracker
+	usage     *usageDeltaTracker
+	// users keeps the CPU and I/O counters of every user seen since the exporter started.
+	users *usageCounterSet[string]
+	// sessionUsage keeps the CPU and I/O counters of the current login sessions.
+	sessionUsage *usageCounterSet[sessionKey]
+
+	mu           sync.Mutex
+	options      collectorOptions
+	containers   containerResolvers
+	kubelet      *kubeletResolver
+	filter       *processFilter
+	grouper      *processGrouper
+	groups       *groupTracker
+	sessions     *sessionTracker
+	enricher     *sessionEnricher
+	policies     *sessionPolicies
+	failedLogins *failedLoginTracker
+	logind       *logindClient
+}
+
+func NewCollector(options collectorOptions) *Collector {
+	osDist, osVersion, err := getOSInfo()
+	if err != nil {
+		slog.Warn("Cannot get OS information", "error", err)
+	}
+	return &Collector{
+		osDist:       osDist,
+		osVersion:    osVersion,
+		ioRates:      newIORateTracker(),
+		usage:        newUsageDeltaTracker(),
+		users:        newUsageCounterSet[string](true),
+		sessionUsage: newUsageCounterSet[sessionKey](false),
+		options:      options,
+		containers:   newContainerResolvers(options.Runtimes),
+		kubelet:      newKubeletResolver(options.Runtimes.KubeletURL),
+		filter:       mustProcessFilter(options.Filters),
+		grouper:      mustProcessGrouper(options.Groups),
+		groups:       newGroupTracker(),
+		sessions:     newSessionTracker(options.Sessions),
+		enricher:     mustSessionEnricher(options.Sessions),
+		policies:     mustSessionPolicies(options.Rules, options.Sessions.Sites),
+		failedLogins: newFailedLoginTracker(options.FailedLogins),
+		logind:       newLogindClient(options.Logind.BusAddress),
+	}
+}
+
+// mustProcessFilter compiles filters that were already validated with the configuration.
+func mustProcessFilter(filters FiltersConfig) *processFilter {
+	filter, err := newProcessFilter(filters)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
+
+// mustProcessGrouper compiles group rules that were already validated with the configuration.
+func mustProcessGrouper(groups []GroupConfig) *processGrouper {
+	grouper, err := newProcessGrouper(groups)
+	if err != nil {
+		panic(err)
+	}
+	return grouper
+}
+
+// mustSessionEnricher builds the enricher of session settings that were already validated with the configuration.
+func mustSessionEnricher(sessions SessionsConfig) *sessionEnricher {
+	enricher, err := newSessionEnricher(sessions)
+	if err != nil {
+		panic(err)
+	}
+	return enricher
+}
+
+// mustSessionPolicies compiles rules that were already validated with the configuration.
+func mustSessionPolicies(rules []RuleConfig, sites []SiteConfig) *sessionPolicies {
+	policies, err := newSessionPolicies(rules, sites)
+	if err != nil {
+		panic(err)
+	}
+	return policies
+}
+
+// SetOptions replaces the options used by the following collections.
+func (c *Collector) SetOptions(options collectorOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if options.Runtimes != c.options.Runtimes {
+		c.containers.Close()
+		c.containers = newContainerResolvers(options.Runtimes)
+		c.kubelet = newKubeletResolver(options.Runtimes.KubeletURL)
+	}
+	c.filter = mustProcessFilter(options.Filters)
+	if !reflect.DeepEqual(options.Groups, c.options.Groups) {
+		// The group counters start over, the processes of a group may have changed
+		c.grouper = mustProcessGrouper(options.Groups)
+		c.groups = newGroupTracker()
+	}
+	if options.Sessions.Wtmp != c.options.Sessions.Wtmp || options.Sessions.ReplayWtmp != c.options.Sessions.ReplayWtmp {
+		c.sessions = newSessionTracker(options.Sessions)
+	}
+	c.enricher = mustSessionEnricher(options.Sessions)
+	c.policies = mustSessionPolicies(options.Rules, options.Sessions.Sites)
+	if options.FailedLogins != c.options.FailedLogins {
+		c.failedLogins = newFailedLoginTracker(options.FailedLogins)
+	}
+	if options.Logind != c.options.Logind {
+		c.logind.Close()
+		c.logind = newLogindClient(options.Logind.BusAddress)
+	}
+	c.options = options
+}
+
+// Collect reads the current sessions and processes and resolves the container of every process.
+func (c *Collector) Collect() (*Snapshot, error) {
+	c.mu.Lock()
+	options := c.options
+	containers := c.containers
+	kubelet := c.kubelet
+	filter := c.filter
+	grouper := c.grouper
+	groups := c.groups
+	sessionTracker := c.sessions
+	enricher := c.enricher
+	policies := c.policies
+	failedLoginTracker := c.failedLogins
+	logind := c.logind
+	c.mu.Unlock()
+
+	now := time.Now()
+	hostname, err := getHostname()
+	if err != nil {
+		slog.Warn("Cannot get hostname", "error", err)
+	}
+	var sessions []Session
+	var lifecycle SessionLifecycle
+	var violations []PolicyViolation
+	if options.Collectors.Sessions {
+		sessions, err = getLoggedInUsers()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching logged-in users: %w", err)
+		}
+		enricher.enrich(sessions)
+		lifecycle = sessionTracker.update(sessions, now, enricher)
+		violations = policies.check(sessions)
+	}
+	var logindState *LogindState
+	if options.Collectors.Logind {
+		logindState, err = logind.State()
+		if err != nil {
+			// The sessions are still read from utmp
+			slog.Warn("Cannot get sessions from logind", "error", err)
+		}
+	}
+	var failedLogins []FailedLogin
+	if options.FailedLogins.enabled() {
+		failedLogins = failedLoginTracker.update()
+	}
+	var processes []Process
+	if options.Collectors.Processes {
+		processes, err = readProcesses()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching processes: %w", err)
+		}
+	}
+
+	var cgroups []CgroupStats
+	if options.Collectors.Cgroups {
+		cgroups, err = readCgroupStats(options.Cgroups.Root, options.Cgroups.MaxDepth)
+		if err != nil {
+			// The other collections are still useful without the cgroup usage
+			slog.Warn("Cannot read cgroup usage", "root", options.Cgroups.Root, "error", err)
+		}
+	}
+
+	rates := c.ioRates.update(processes, now)
+	deltas := c.usage.update(processes, now)
+	if options.Collectors.Processes {
+		attributeSessions(sessions, processes, deltas, c.sessionUsage)
+	}
+	samples := make([]ProcessSample, 0, len(processes))
+	for _, p := range processes {
+		if !options.Filters.Users.match(p.User) {
+			continue
+		}
+		sample := ProcessSample{Process: p}
+		sample.IORate, sample.HasIORate = rates[p.PID]
+		sample.Delta = deltas[p.PID]
+		if grouper.needsCgroup() {
+			if cgroup, err := readProcCgroup(procRoot, p.PID); err == nil {
+				sample.Cgroup = cgroup.Path()
+			}
+		}
+		samples = append(samples, sample)
+	}
+	// The per-user and per-group totals include every process of the users filters.users keeps,
+	// the other filters only select the per-process series
+	users := aggregateUsers(samples, sessions, options.Sessions.StaleAfter, c.users)
+	groupUsage := groups.update(grouper, samples, now)
+	samples = filter.apply(samples)
+
+	for i := range samples {
+		sample := &samples[i]
+		if options.Collectors.Containers {
+			cgroup, container, err := checkCgroup(sample.PID)
+			if err != nil {
+				slog.Debug("Cannot check cgroup", "pid", sample.PID, "error", err)
+			} else {
+				sample.Cgroup = cgroup.Path()
+				identifyContainer(sample, sample.Cgroup, container, containers, kubelet)
+				slog.Debug("Identified the container of a process", "pid", sample.PID, "cgroup", sample.Cgroup, "container_id", sample.ContainerID, "container_name", sample.ContainerName)
+			}
+		}
+		if sample.ContainerID == "" {
+			sample.ContainerID = "0 N/A"
+			sample.ContainerName = "0 N/A"
+		}
+	}
+
+	return &Snapshot{
+		Time:         now,
+		Duration:     time.Since(now),
+		Hostname:     hostname,
+		OS:           c.osDist,
+		OSVersion:    c.osVersion,
+		Sessions:     sessions,
+		Lifecycle:    lifecycle,
+		FailedLogins: failedLogins,
+		Logind:       logindState,
+		Violations:   violations,
+		Processes:    samples,
+		Cgroups:      cgroups,
+		Users:        users,
+		Groups:       groupUsage,
+	}, nil
+}
+
+// identifyContainer fills in the container and, for Kubernetes, the pod of a process from
+// its cgroup path. The kubelet names the pod and container; the labels the runtime keeps
+// on the container are the fallback when the kubelet cannot be asked.
+func identifyContainer(sample *ProcessSample, cgroupPath string, container containerRef, containers containerResolvers, kubelet *kubeletResolver) {
+	pod, podContainerID, inPod := parseKubepodsPath(cgroupPath)
+	if container.ID == "" {
+		container.ID = podContainerID
+	}
+	if container.ID == "" {
+		return
+	}
+	var kpod kubeletPod
+	if inPod {
+		var found bool
+		var err error
+		kpod, found, err = kubelet.Pod(pod.UID, container.ID)
+		if err != nil {
+			slog.Debug("Cannot ask kubelet for pod", "pod_uid", pod.UID, "error", err)
+		}
+		if found {
+			pod.Name = kpod.Name
+			pod.Namespace = kpod.Namespace
+			pod.Container = kpod.Containers[container.ID]
+			if container.Runtime == "" {
+				container.Runtime = kpod.Runtimes[container.ID]
+			}
+		}
+	}
+
+	sample.ContainerID = container.ID
+	sample.ContainerRuntime = container.Runtime
+	var info containerInfo
+	if container.Runtime != "" {
+		var err error
+		info, err = containers.Resolve(container)
+		if err != nil {
+			slog.Debug("Cannot get container name", "runtime", container.Runtime, "container_id", container.ID, "error", err)
+		}
+		sample.ContainerName = info.Name
+		sample.ContainerImage = info.Image
+	}
+	if inPod {
+		podFromLabels(&pod, info.Labels)
+		if pod.Container != "" {
+			sample.ContainerName = pod.Container
+		}
+		sample.Pod = &pod
+	}
+}
+
+// CollectInto collects one snapshot and hands it to every sink. A failing sink is
+// logged and does not stop the others.
+func (c *Collector) CollectInto(sinks ...Sink) error {
+	snapshot, err := c.Collect()
+	if err != nil {
+		return err
+	}
+	for _, sink := range sinks {
+		if err := sink.Write(snapshot); err != nil {
+			slog.Error("Cannot write snapshot", "sink", sink.Name(), "error", err)
+		}
+	}
+	return nil
+}
+
+// Run collects a snapshot immediately and then every interval, handing each one to
+// the sinks, until ctx is cancelled. Sinks never trigger a collection themselves, so
+// the cost of collecting does not depend on how often they are read.
+func (c *Collector) Run(ctx context.Context, interval time.Duration, sinks ...Sink) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.CollectInto(sinks...); err != nil {
+			slog.Error("Error collecting metrics", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variables that override the configuration file.
+const envPrefix = "LOGGED_USERS_"
+
+// Config is the complete exporter configuration. It is built from the defaults, the
+// configuration file, the LOGGED_USERS_* environment variables and the command line
+// flags, each overriding the previous one.
+type Config struct {
+	// Listen is the address of the /metrics HTTP listener.
+	Listen string `yaml:"listen"`
+	// Interval is the time between collections.
+	Interval time.Duration `yaml:"interval"`
+	// Output is prometheus, influxdb or both. When empty it is both if an InfluxDB URL is set, otherwise prometheus.
+	Output     string           `yaml:"output"`
+	InfluxDB   InfluxDBConfig   `yaml:"influxdb"`
+	Collectors CollectorsConfig `yaml:"collectors"`
+	Sessions   SessionsConfig   `yaml:"sessions"`
+	// FailedLogins are the sources of the failed login counters, all off by default.
+	FailedLogins FailedLoginsConfig `yaml:"failed_logins"`
+	Filters      FiltersConfig      `yaml:"filters"`
+	Labels       LabelsConfig       `yaml:"labels"`
+	Runtimes     RuntimesConfig     `yaml:"runtimes"`
+	Cgroups      CgroupsConfig      `yaml:"cgroups"`
+	Logind       LogindConfig       `yaml:"logind"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
+	// Groups are the rules that sum processes into named groups, tried in order.
+	Groups []GroupConfig `yaml:"groups"`
+	// Rules are the session policies checked on every collection.
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+type InfluxDBConfig struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+	// TokenFile is read when Token is empty, so the token does not have to appear in the file, argv or the environment.
+	TokenFile  string `yaml:"token_file"`
+	Org        string `yaml:"org"`
+	Bucket     string `yaml:"bucket"`
+	BatchSize  int    `yaml:"batch_size"`
+	BufferSize int    `yaml:"buffer_size"`
+	MaxRetries int    `yaml:"max_retries"`
+}
+
+// CollectorsConfig enables the parts of a collection. Disabled parts are not read at all.
+type CollectorsConfig struct {
+	Sessions  bool `yaml:"sessions"`
+	Processes bool `yaml:"processes"`
+	// Containers resolves the container of every process from its cgroup.
+	Containers bool `yaml:"containers"`
+	// Cgroups reads the resource usage of every cgroup, see CgroupsConfig.
+	Cgroups bool `yaml:"cgroups"`
+	// Logind asks systemd-logind for its sessions, seats and users, see LogindConfig.
+	Logind bool `yaml:"logind"`
+}
+
+// SessionsConfig controls how logins and logouts are counted.
+type SessionsConfig struct {
+	// Wtmp is the login history file read for the logins and logouts, which also catches sessions
+	// shorter than the interval. Empty compares the logged in sessions of successive collections.
+	Wtmp string `yaml:"wtmp"`
+	// ReplayWtmp counts the history already in Wtmp at startup instead of only what follows.
+	ReplayWtmp bool `yaml:"replay_wtmp"`
+	// StaleAfter is the idle time after which a session counts as stale, 0 counts none.
+	StaleAfter time.Duration `yaml:"stale_after"`
+	// ReverseDNS resolves the remote address of the sessions to a host name, cached for ReverseDNSTTL.
+	ReverseDNS    bool          `yaml:"reverse_dns"`
+	ReverseDNSTTL time.Duration `yaml:"reverse_dns_ttl"`
+	// Sites name the networks sessions come from. The most specific network wins.
+	Sites []SiteConfig `yaml:"sites"`
+}
+
+type SiteConfig struct {
+	Name string `yaml:"name"`
+	// Networks are CIDR prefixes such as 10.8.0.0/16.
+	Networks []string `yaml:"networks"`
+}
+
+// FailedLoginsConfig selects where failed login attempts are read from. Every source is
+// read from where the previous collection stopped.
+type FailedLoginsConfig struct {
+	// Btmp is the failed login file written by login and sshd, e.g. /var/log/btmp.
+	Btmp string `yaml:"btmp"`
+	// AuthLog is a syslog file with the sshd messages, e.g. /var/log/auth.log or /var/log/secure.
+	AuthLog string `yaml:"auth_log"`
+	// Journal reads the sshd messages from the systemd journal with journalctl.
+	Journal bool `yaml:"journal"`
+	// Replay counts the attempts already logged at startup instead of only what follows.
+	Replay bool `yaml:"replay"`
+}
+
+// enabled reports whether any source is configured.
+func (c FailedLoginsConfig) enabled() bool {
+	return c.Btmp != "" || c.AuthLog != "" || c.Journal
+}
+
+// LogindConfig locates systemd-logind.
+type LogindConfig struct {
+	// BusAddress is the D-Bus address logind is asked on, e.g. unix:path=/run/dbus/system_bus_socket.
+	// Empty is the system bus, which DBUS_SYSTEM_BUS_ADDRESS overrides.
+	BusAddress string `yaml:"bus_address"`
+}
+
+// CgroupsConfig controls the per-cgroup usage collection.
+type CgroupsConfig struct {
+	// Root is where the cgroup file system is mounted.
+	Root string `yaml:"root"`
+	// MaxDepth limits how many levels below the root cgroup are reported, e.g. 2 reaches
+	// /system.slice/docker.service and 3 /user.slice/user-1000.slice/session-1.scope.
+	MaxDepth int `yaml:"max_depth"`
+}
+
+// FiltersConfig selects the processes that are reported. Users applies to everything, the
+// other filters only decide which processes get their own series.
+type FiltersConfig struct {
+	Users    UserFilter    `yaml:"users"`
+	Commands CommandFilter `yaml:"commands"`
+	// ExcludeKernelThreads drops kernel threads, recognised by their PF_KTHREAD flag.
+	ExcludeKernelThreads bool `yaml:"exclude_kernel_threads"`
+	// Min drops the processes below any of the thresholds.
+	Min ThresholdsConfig `yaml:"min"`
+	Top TopConfig        `yaml:"top"`
+}
+
+// CommandFilter matches regular expressions against the full command line. When Allow is
+// set only matching processes are kept; processes matching Deny are always dropped.
+type CommandFilter struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+type ThresholdsConfig struct {
+	// CPUPercent is compared with the CPU use since the previous collection, in percent of one CPU.
+	CPUPercent float64 `yaml:"cpu_percent"`
+	RSSBytes   uint64  `yaml:"rss_bytes"`
+	// IOBytesPerSec is the read and write throughput since the previous collection.
+	IOBytesPerSec float64 `yaml:"io_bytes_per_second"`
+}
+
+// TopConfig keeps only the N processes ranked highest by cpu, rss or io. N 0 keeps all.
+type TopConfig struct {
+	N  int    `yaml:"n"`
+	By string `yaml:"by"`
+}
+
+// UserFilter keeps the processes of the Include users, or of all users when Include is
+// empty, except those of the Exclude users.
+type UserFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+func (f UserFilter) match(user string) bool {
+	if len(f.Include) > 0 && !slices.Contains(f.Include, user) {
+		return false
+	}
+	return !slices.Contains(f.Exclude, user)
+}
+
+// GroupConfig is a process group rule. A process joins the group of the first rule whose
+// selectors all match it, and a rule needs at least one selector.
+type GroupConfig struct {
+	// Name is a text/template over groupNameData, e.g. "{{.Comm}}" for a group per command name.
+	Name string `yaml:"name"`
+	// Comm matches any of the command names from /proc/<pid>/comm.
+	Comm []string `yaml:"comm"`
+	// Exe matches any of the executables, by full path when it contains a slash, else by base name.
+	Exe []string `yaml:"exe"`
+	// Cmdline are regular expressions that must all match the full command line. Their named
+	// captures are available to Name as .Matches.
+	Cmdline []string `yaml:"cmdline"`
+	// Cgroup matches any of the regular expressions against the cgroup path.
+	Cgroup []string `yaml:"cgroup"`
+}
+
+// RuleConfig is a session policy. It applies to the sessions of Users whose kind is one of
+// Kinds, all kinds when Kinds is empty. A user breaks it with every session over MaxSessions
+// and every remote session from outside AllowNetworks and AllowSites, each session counted
+// once; a rule needs a limit, an allowed source or both.
+type RuleConfig struct {
+	Name  string     `yaml:"name"`
+	Users UserFilter `yaml:"users"`
+	// Kinds are console, tmux, screen, ssh and x11, see classifySession.
+	Kinds []string `yaml:"kinds"`
+	// MaxSessions is how many sessions a user may have at once, 0 for no limit.
+	MaxSessions int `yaml:"max_sessions"`
+	// AllowNetworks are CIDR prefixes remote sessions may come from. Local sessions are always allowed.
+	AllowNetworks []string `yaml:"allow_networks"`
+	// AllowSites are names of sessions.sites remote sessions may come from.
+	AllowSites []string `yaml:"allow_sites"`
+}
+
+// RuntimesConfig locates the container runtime APIs used to resolve container names.
+type RuntimesConfig struct {
+	DockerSocket string `yaml:"docker_socket"`
+	// PodmanSocket serves Podman's Docker compatible API.
+	PodmanSocket string `yaml:"podman_socket"`
+	CRIOSocket   string `yaml:"crio_socket"`
+	// ContainerdTaskRoot holds the OCI bundles of the running containerd tasks.
+	ContainerdTaskRoot string `yaml:"containerd_task_root"`
+	// KubeletURL is the kubelet read-only API used to name pods. Empty disables it, the
+	// container runtime labels are used instead.
+	KubeletURL string `yaml:"kubelet_url"`
+}
+
+// MetricsConfig selects the series families written by every output.
+type MetricsConfig struct {
+	// PerProcess writes a series per process, or per group of processes sharing the labels.processes values.
+	PerProcess bool `yaml:"per_process"`
+	// PerUser writes the usage summed by user.
+	PerUser bool `yaml:"per_user"`
+}
+
+type LabelsConfig struct {
+	// Process are the identity labels of the per-process series.
+	Process []string `yaml:"process"`
+	// Schema is the --metrics-schema setting.
+	Schema string `yaml:"schema"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Listen:   ":8080",
+		Interval: 5 * time.Second,
+		InfluxDB: InfluxDBConfig{
+			BatchSize:  1000,
+			BufferSize: 100000,
+			MaxRetries: 5,
+		},
+		Collectors: CollectorsConfig{Sessions: true, Processes: true, Containers: true, Cgroups: true},
+		Filters: FiltersConfig{
+			ExcludeKernelThreads: true,
+			Top:                  TopConfig{By: rankByRSS},
+		},
+		Labels: LabelsConfig{
+			Process: slices.Clone(defaultProcessLabels),
+			Schema:  schemaCurrent,
+		},
+		Runtimes: RuntimesConfig{
+			DockerSocket:       defaultDockerSocket,
+			PodmanSocket:       defaultPodmanSocket,
+			CRIOSocket:         defaultCRIOSocket,
+			ContainerdTaskRoot: defaultContainerdTaskRoot,
+			KubeletURL:         defaultKubeletURL,
+		},
+		Sessions: SessionsConfig{StaleAfter: time.Hour, ReverseDNSTTL: 10 * time.Minute},
+		Cgroups:  CgroupsConfig{Root: defaultCgroupRoot, MaxDepth: 3},
+		Metrics:  MetricsConfig{PerProcess: true, PerUser: true},
+	}
+}
+
+// readConfigFile decodes a YAML configuration file over the defaults. Unknown keys are
+// rejected so a typo does not silently fall back to a default.
+func readConfigFile(path string) (Config, error) {
+	config := defaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// applyEnv overrides the configuration with the LOGGED_USERS_* environment variables that are set.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	str := func(name string, field *string) {
+		if v, ok := lookup(envPrefix + name); ok {
+			*field = v
+		}
+	}
+	integer := func(name string, field *int) {
+		if v, ok := lookup(envPrefix + name); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+				return
+			}
+			*field = n
+		}
+	}
+	boolean := func(name string, field *bool) {
+		if v, ok := lookup(envPrefix + name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+				return
+			}
+			*field = b
+		}
+	}
+	number := func(name string, field *float64) {
+		if v, ok := lookup(envPrefix + name); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+				return
+			}
+			*field = f
+		}
+	}
+	duration := func(name string, field *time.Duration) {
+		if v, ok := lookup(envPrefix + name); ok {
+			d, err := parseInterval(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+				return
+			}
+			*field = d
+		}
+	}
+	list := func(name string, field *[]string) {
+		if v, ok := lookup(envPrefix + name); ok {
+			*field = splitList(v)
+		}
+	}
+
+	str("LISTEN", &c.Listen)
+	duration("INTERVAL", &c.Interval)
+	str("OUTPUT", &c.Output)
+	str("INFLUX_URL", &c.InfluxDB.URL)
+	str("INFLUX_TOKEN", &c.InfluxDB.Token)
+	str("INFLUX_TOKEN_FILE", &c.InfluxDB.TokenFile)
+	str("INFLUX_ORG", &c.InfluxDB.Org)
+	str("INFLUX_BUCKET", &c.InfluxDB.Bucket)
+	integer("INFLUX_BATCH_SIZE", &c.InfluxDB.BatchSize)
+	integer("INFLUX_BUFFER_SIZE", &c.InfluxDB.BufferSize)
+	integer("INFLUX_MAX_RETRIES", &c.InfluxDB.MaxRetries)
+	boolean("COLLECT_SESSIONS", &c.Collectors.Sessions)
+	boolean("COLLECT_PROCESSES", &c.Collectors.Processes)
+	boolean("COLLECT_CONTAINERS", &c.Collectors.Containers)
+	boolean("COLLECT_CGROUPS", &c.Collectors.Cgroups)
+	boolean("COLLECT_LOGIND", &c.Collectors.Logind)
+	str("WTMP", &c.Sessions.Wtmp)
+	boolean("REPLAY_WTMP", &c.Sessions.ReplayWtmp)
+	duration("STALE_AFTER", &c.Sessions.StaleAfter)
+	boolean("REVERSE_DNS", &c.Sessions.ReverseDNS)
+	duration("REVERSE_DNS_TTL", &c.Sessions.ReverseDNSTTL)
+	str("BTMP", &c.FailedLogins.Btmp)
+	str("AUTH_LOG", &c.FailedLogins.AuthLog)
+	boolean("AUTH_JOURNAL", &c.FailedLogins.Journal)
+	boolean("REPLAY_FAILED_LOGINS", &c.FailedLogins.Replay)
+	list("INCLUDE_USERS", &c.Filters.Users.Include)
+	list("EXCLUDE_USERS", &c.Filters.Users.Exclude)
+	list("ALLOW_COMMANDS", &c.Filters.Commands.Allow)
+	list("DENY_COMMANDS", &c.Filters.Commands.Deny)
+	boolean("EXCLUDE_KERNEL_THREADS", &c.Filters.ExcludeKernelThreads)
+	number("MIN_CPU_PERCENT", &c.Filters.Min.CPUPercent)
+	if v, ok := lookup(envPrefix + "MIN_RSS_BYTES"); ok {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%sMIN_RSS_BYTES: %w", envPrefix, err))
+		} else {
+			c.Filters.Min.RSSBytes = n
+		}
+	}
+	number("MIN_IO_BYTES_PER_SECOND", &c.Filters.Min.IOBytesPerSec)
+	integer("TOP_N", &c.Filters.Top.N)
+	str("TOP_BY", &c.Filters.Top.By)
+	list("PROCESS_LABELS", &c.Labels.Process)
+	str("METRICS_SCHEMA", &c.Labels.Schema)
+	str("DOCKER_SOCKET", &c.Runtimes.DockerSocket)
+	str("PODMAN_SOCKET", &c.Runtimes.PodmanSocket)
+	str("CRIO_SOCKET", &c.Runtimes.CRIOSocket)
+	str("CONTAINERD_TASK_ROOT", &c.Runtimes.ContainerdTaskRoot)
+	str("KUBELET_URL", &c.Runtimes.KubeletURL)
+	str("CGROUP_ROOT", &c.Cgroups.Root)
+	integer("CGROUP_MAX_DEPTH", &c.Cgroups.MaxDepth)
+	str("LOGIND_BUS_ADDRESS", &c.Logind.BusAddress)
+	boolean("PER_PROCESS_METRICS", &c.Metrics.PerProcess)
+	boolean("PER_USER_METRICS", &c.Metrics.PerUser)
+	return errors.Join(errs...)
+}
+
+// parseInterval accepts a Go duration such as 30s, or a plain number of seconds like --interval.
+func parseInterval(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// resolve fills in the settings derived from others: the default output and the token from TokenFile.
+func (c *Config) resolve() error {
+	if c.Output == "" {
+		c.Output = outputPrometheus
+		if c.InfluxDB.URL != "" {
+			c.Output = outputBoth
+		}
+	}
+	if c.InfluxDB.Token == "" && c.InfluxDB.TokenFile != "" {
+		data, err := os.ReadFile(c.InfluxDB.TokenFile)
+		if err != nil {
+			return fmt.Errorf("influxdb.token_file: %w", err)
+		}
+		c.InfluxDB.Token = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// validate reports every invalid setting at once, named by its configuration key.
+func (c *Config) validate() error {
+	var errs []error
+	if c.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("interval: must be positive, got %s", c.Interval))
+	}
+	if c.Sessions.StaleAfter < 0 {
+		errs = append(errs, fmt.Errorf("sessions.stale_after: must not be negative, got %s", c.Sessions.StaleAfter))
+	}
+	switch c.Output {
+	case outputPrometheus, outputBoth:
+		if c.Listen == "" {
+			errs = append(errs, errors.New("listen: must not be empty"))
+		}
+	case outputInfluxDB:
+	default:
+		errs = append(errs, fmt.Errorf("output: unknown output %q, valid outputs are %s, %s and %s", c.Output, outputPrometheus, outputInfluxDB, outputBoth))
+	}
+	if c.influxEnabled() {
+		if err := c.influxOptions().validate(); err != nil {
+			errs = append(errs, fmt.Errorf("influxdb: %w", err))
+		}
+	}
+	if c.Collectors.Cgroups && c.Cgroups.MaxDepth < 1 {
+		errs = append(errs, fmt.Errorf("cgroups.max_depth: must be at least 1, got %d", c.Cgroups.MaxDepth))
+	}
+	if _, err := newSessionEnricher(c.Sessions); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newSessionPolicies(c.Rules, c.Sessions.Sites); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newProcessGrouper(c.Groups); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newProcessFilter(c.Filters); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateProcessLabels(c.Labels.Process); err != nil {
+		errs = append(errs, fmt.Errorf("labels.process: %w", err))
+	}
+	if err := validateMetricsSchema(c.Labels.Schema); err != nil {
+		errs = append(errs, fmt.Errorf("labels.schema: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) prometheusEnabled() bool {
+	return c.Output == outputPrometheus || c.Output == outputBoth
+}
+
+func (c *Config) influxEnabled() bool {
+	return c.Output == outputInfluxDB || c.Output == outputBoth
+}
+
+func (c *Config) influxOptions() influxOptions {
+	return influxOptions{
+		URL:        c.InfluxDB.URL,
+		Token:      c.InfluxDB.Token,
+		Org:        c.InfluxDB.Org,
+		Bucket:     c.InfluxDB.Bucket,
+		BatchSize:  c.InfluxDB.BatchSize,
+		BufferSize: c.InfluxDB.BufferSize,
+		MaxRetries: c.InfluxDB.MaxRetries,
+		PerProcess: c.Metrics.PerProcess,
+		PerUser:    c.Metrics.PerUser,
+	}
+}
+
+func (c *Config) prometheusOptions() prometheusOptions {
+	return prometheusOptions{
+		ProcessLabels: c.Labels.Process,
+		Schema:        c.Labels.Schema,
+		PerProcess:    c.Metrics.PerProcess,
+		PerUser:       c.Metrics.PerUser,
+	}
+}
+
+func (c *Config) collectorOptions() collectorOptions {
+	return collectorOptions{Collectors: c.Collectors, Filters: c.Filters, Runtimes: c.Runtimes, Cgroups: c.Cgroups, Groups: c.Groups, Sessions: c.Sessions, FailedLogins: c.FailedLogins, Logind: c.Logind, Rules: c.Rules}
+}
+
+// loadConfig builds the configuration from the defaults, the file at path (if any), the
+// environment and finally applyFlags, and validates the result.
+func loadConfig(path string, applyFlags func(*Config) error) (Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		var err error
+		if config, err = readConfigFile(path); err != nil {
+			return config, fmt.Errorf("cannot read configuration file: %w", err)
+		}
+	}
+	if err := config.applyEnv(os.LookupEnv); err != nil {
+		return config, err
+	}
+	if err := applyFlags(&config); err != nil {
+		return config, err
+	}
+	if err := config.resolve(); err != nil {
+		return config, err
+	}
+	return config, config.validate()
+}
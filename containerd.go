@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const defaultContainerdTaskRoot = "/run/containerd/io.containerd.runtime.v2.task"
+
+// containerdTaskResolver reads container metadata from the OCI bundle containerd's v2
+// runtime shim keeps for every running task under <root>/<namespace>/<id>/config.json.
+// The CRI plugin records the Kubernetes names as annotations in that spec.
+type containerdTaskResolver struct {
+	root string
+}
+
+func (r containerdTaskResolver) inspect(id string) (containerInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(r.root, "*", id, "config.json"))
+	if err != nil {
+		return containerInfo{}, err
+	}
+	if len(matches) == 0 {
+		return containerInfo{}, fmt.Errorf("%w: %s", errContainerNotFound, id)
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		return containerInfo{}, err
+	}
+	var spec struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return containerInfo{}, fmt.Errorf("cannot decode %s: %w", matches[0], err)
+	}
+	return containerInfo{
+		ID:     id,
+		Name:   spec.Annotations["io.kubernetes.cri.container-name"],
+		Image:  spec.Annotations["io.kubernetes.cri.image-name"],
+		Labels: spec.Annotations,
+	}, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Container runtimes recognised in cgroup paths.
+const (
+	runtimeDocker     = "docker"
+	runtimeContainerd = "containerd"
+	runtimeCRIO       = "cri-o"
+	runtimePodman     = "podman"
+	runtimeLXC        = "lxc"
+)
+
+// containerRef identifies the container a cgroup belongs to.
+type containerRef struct {
+	Runtime string
+	ID      string
+}
+
+// cgroupClassifier recognises the cgroup path components of one container runtime. match
+// is called with every component of a path and its parent component, starting with the
+// deepest, and returns the container ID when the component names a container.
+type cgroupClassifier struct {
+	runtime string
+	match   func(parent, component string) (string, bool)
+}
+
+// cgroupClassifiers are tried in order for every path component. Add an entry to support
+// another runtime.
+var cgroupClassifiers = []cgroupClassifier{
+	// cgroupfs driver: /docker/<id>, systemd driver: /system.slice/docker-<id>.scope
+	{runtimeDocker, func(parent, component string) (string, bool) {
+		if parent == "docker" && isContainerID(component) {
+			return component, true
+		}
+		return scopeContainerID(component, "docker-")
+	}},
+	// Kubernetes with containerd: cri-containerd-<id>.scope
+	{runtimeContainerd, func(parent, component string) (string, bool) {
+		return scopeContainerID(component, "cri-containerd-")
+	}},
+	// crio-<id>.scope; the crio-conmon-<id>.scope of the monitor is not a container ID and does not match
+	{runtimeCRIO, func(parent, component string) (string, bool) {
+		return scopeContainerID(component, "crio-")
+	}},
+	// libpod-<id>.scope for rootful and rootless Podman, /libpod_parent/libpod-<id> with cgroupfs
+	{runtimePodman, func(parent, component string) (string, bool) {
+		return scopeContainerID(component, "libpod-")
+	}},
+	// LXC names containers instead of giving them IDs: /lxc/<name> (v1) or /lxc.payload.<name> (LXC 4+)
+	{runtimeLXC, func(parent, component string) (string, bool) {
+		if name, ok := strings.CutPrefix(component, "lxc.payload."); ok && name != "" {
+			return name, true
+		}
+		if (parent == "lxc" || parent == "lxc.payload") && component != "" {
+			return component, true
+		}
+		return "", false
+	}},
+}
+
+// classifyCgroupPath returns the container of the deepest path component any classifier recognises.
+func classifyCgroupPath(cgroupPath string) (containerRef, bool) {
+	components := strings.Split(strings.Trim(cgroupPath, "/"), "/")
+	for i := len(components) - 1; i >= 0; i-- {
+		parent := ""
+		if i > 0 {
+			parent = components[i-1]
+		}
+		for _, classifier := range cgroupClassifiers {
+			if id, ok := classifier.match(parent, components[i]); ok {
+				return containerRef{Runtime: classifier.runtime, ID: id}, true
+			}
+		}
+	}
+	return containerRef{}, false
+}
+
+// scopeContainerID extracts the ID from a <prefix><id>[.scope] component.
+func scopeContainerID(component, prefix string) (string, bool) {
+	id, ok := strings.CutPrefix(component, prefix)
+	if !ok {
+		return "", false
+	}
+	id = strings.TrimSuffix(id, ".scope")
+	return id, isContainerID(id)
+}
+
+// isContainerID reports whether s is a full 64 hex digit container ID as used by Docker, containerd, CRI-O and Podman.
+func isContainerID(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// containerInfo is the metadata of a container as reported by its runtime.
+type containerInfo struct {
+	ID     string
+	Name   string
+	Image  string
+	Labels map[string]string
+}
+
+// errContainerNotFound is returned when the runtime does not know the container, e.g. because it was just removed.
+var errContainerNotFound = errors.New("container not found")
+
+// unixHTTPClient returns an HTTP client that sends every request to the unix socket at path,
+// whatever the host in the URL.
+func unixHTTPClient(path string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// containerResolver looks up the metadata of a container of one runtime.
+type containerResolver interface {
+	Resolve(id string) (containerInfo, error)
+	Close()
+}
+
+// containerResolvers resolves containers through the API of the runtime that runs them.
+type containerResolvers map[string]containerResolver
+
+func newContainerResolvers(config RuntimesConfig) containerResolvers {
+	return containerResolvers{
+		runtimeDocker: newDockerResolver(config.DockerSocket),
+		// Podman serves a Docker compatible API, including the event stream
+		runtimePodman:     newDockerResolver(config.PodmanSocket),
+		runtimeCRIO:       newCachedResolver(newCRIOResolver(config.CRIOSocket).inspect),
+		runtimeContainerd: newCachedResolver(containerdTaskResolver{root: config.ContainerdTaskRoot}.inspect),
+		runtimeLXC:        lxcResolver{},
+	}
+}
+
+func (r containerResolvers) Resolve(ref containerRef) (containerInfo, error) {
+	resolver, ok := r[ref.Runtime]
+	if !ok {
+		return containerInfo{ID: ref.ID}, fmt.Errorf("no resolver for container runtime %s", ref.Runtime)
+	}
+	return resolver.Resolve(ref.ID)
+}
+
+func (r containerResolvers) Close() {
+	for _, resolver := range r {
+		resolver.Close()
+	}
+}
+
+// lxcResolver returns the name taken from the cgroup path; LXC has no daemon to ask.
+type lxcResolver struct{}
+
+func (lxcResolver) Resolve(id string) (containerInfo, error) {
+	return containerInfo{ID: id, Name: id}, nil
+}
+
+func (lxcResolver) Close() {}
+
+// cachedResolver caches the lookups of a runtime that has no event stream. The metadata of
+// a container does not change in CRI runtimes, so entries only expire to release the
+// containers that are gone. Failed lookups are cached for failureTTL, so a runtime that is
+// down or a container it does not know is not asked for again by every process of it on
+// every collection.
+type cachedResolver struct {
+	lookup     func(id string) (containerInfo, error)
+	ttl        time.Duration
+	failureTTL time.Duration
+
+	mu        sync.Mutex
+	cache     map[string]cachedContainer
+	nextPrune time.Time
+}
+
+type cachedContainer struct {
+	info    containerInfo
+	err     error
+	expires time.Time
+}
+
+func newCachedResolver(lookup func(id string) (containerInfo, error)) *cachedResolver {
+	return &cachedResolver{lookup: lookup, ttl: 10 * time.Minute, failureTTL: 30 * time.Second, cache: map[string]cachedContainer{}}
+}
+
+func (r *cachedResolver) Resolve(id string) (containerInfo, error) {
+	now := time.Now()
+	r.mu.Lock()
+	if now.After(r.nextPrune) {
+		for key, entry := range r.cache {
+			if now.After(entry.expires) {
+				delete(r.cache, key)
+			}
+		}
+		r.nextPrune = now.Add(r.ttl)
+	}
+	if entry, ok := r.cache[id]; ok && now.Before(entry.expires) {
+		r.mu.Unlock()
+		return entry.info, entry.err
+	}
+	r.mu.Unlock()
+
+	info, err := r.lookup(id)
+	entry := cachedContainer{info: info, err: err, expires: now.Add(r.ttl)}
+	if err != nil {
+		entry.expires = now.Add(r.failureTTL)
+	}
+	r.mu.Lock()
+	r.cache[id] = entry
+	r.mu.Unlock()
+	return info, err
+}
+
+func (r *cachedResolver) Close() {}
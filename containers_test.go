@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachedResolver(t *testing.T) {
+	lookups := map[string]int{}
+	resolver := newCachedResolver(func(id string) (containerInfo, error) {
+		lookups[id]++
+		if id == "gone" {
+			return containerInfo{}, errors.New("no such container")
+		}
+		return containerInfo{ID: id, Name: "web"}, nil
+	})
+	for range 3 {
+		if info, err := resolver.Resolve("abc"); err != nil || info.Name != "web" {
+			t.Fatalf("Resolve(abc) = %+v, %v", info, err)
+		}
+		if _, err := resolver.Resolve("gone"); err == nil {
+			t.Fatal("Resolve(gone): no error")
+		}
+	}
+	if lookups["abc"] != 1 || lookups["gone"] != 1 {
+		t.Fatalf("lookups = %v, want one of each", lookups)
+	}
+
+	// Failures are asked for again sooner than the containers that were found
+	if gone, abc := resolver.cache["gone"].expires, resolver.cache["abc"].expires; time.Until(gone) > resolver.failureTTL || !gone.Before(abc) {
+		t.Errorf("failure expires %s, container expires %s", gone, abc)
+	}
+	entry := resolver.cache["gone"]
+	entry.expires = time.Now().Add(-time.Second)
+	resolver.cache["gone"] = entry
+	resolver.Resolve("gone")
+	resolver.Resolve("abc")
+	if lookups["abc"] != 1 || lookups["gone"] != 2 {
+		t.Errorf("lookups = %v after the failure expired", lookups)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// UsageDelta is the CPU time and I/O a process used since the previous collection, or
+// since it started when it is seen for the first time.
+type UsageDelta struct {
+	CPUSeconds float64
+	// IO is only valid when HasIO is set, i.e. /proc/<pid>/io could be read.
+	IO    ProcessIO
+	HasIO bool
+	// Elapsed is the time since the previous collection, 0 when the process is new.
+	Elapsed time.Duration
+}
+
+type usageSample struct {
+	start      int64
+	at         time.Time
+	cpuSeconds float64
+	io         ProcessIO
+	hasIO      bool
+}
+
+// usageDeltaTracker remembers the CPU time and I/O counters of every process, so sums over
+// a changing set of processes can be kept as counters, see usageCounterSet. Like
+// ioRateTracker it matches a PID together with its start time.
+type usageDeltaTracker struct {
+	mu   sync.Mutex
+	prev map[int]usageSample
+}
+
+func newUsageDeltaTracker() *usageDeltaTracker {
+	return &usageDeltaTracker{prev: map[int]usageSample{}}
+}
+
+// update records the current counters and returns the growth of every process by PID.
+func (t *usageDeltaTracker) update(processes []Process, now time.Time) map[int]UsageDelta {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	deltas := make(map[int]UsageDelta, len(processes))
+	current := make(map[int]usageSample, len(processes))
+	for i := range processes {
+		p := &processes[i]
+		sample := usageSample{start: p.StartTime.UnixNano(), at: now, cpuSeconds: p.CPUSeconds(), io: p.IO, hasIO: p.HasIO}
+		prev, ok := t.prev[p.PID]
+		if !ok || prev.start != sample.start {
+			prev = usageSample{}
+		}
+		if !sample.hasIO && prev.hasIO {
+			// Keep the last readable counters, so the next readable ones are not counted twice
+			sample.io, sample.hasIO = prev.io, true
+		}
+		current[p.PID] = sample
+		delta := UsageDelta{CPUSeconds: max(sample.cpuSeconds-prev.cpuSeconds, 0)}
+		if !prev.at.IsZero() {
+			delta.Elapsed = now.Sub(prev.at)
+		}
+		if p.HasIO {
+			delta.HasIO = true
+			delta.IO = ProcessIO{
+				RChar:               uint64(counterDelta(prev.io.RChar, p.IO.RChar)),
+				WChar:               uint64(counterDelta(prev.io.WChar, p.IO.WChar)),
+				SyscR:               uint64(counterDelta(prev.io.SyscR, p.IO.SyscR)),
+				SyscW:               uint64(counterDelta(prev.io.SyscW, p.IO.SyscW)),
+				ReadBytes:           uint64(counterDelta(prev.io.ReadBytes, p.IO.ReadBytes)),
+				WriteBytes:          uint64(counterDelta(prev.io.WriteBytes, p.IO.WriteBytes)),
+				CancelledWriteBytes: uint64(counterDelta(prev.io.CancelledWriteBytes, p.IO.CancelledWriteBytes)),
+			}
+		}
+		deltas[p.PID] = delta
+	}
+	t.prev = current
+	return deltas
+}
+
+// UsageCounters are the CPU time and I/O of a set of processes as counters: they include
+// what the members used before they exited or left the set.
+type UsageCounters struct {
+	CPUSeconds float64
+	IO         ProcessIO
+	// HasIO is set once the I/O counters of a member could be read.
+	HasIO bool
+}
+
+func (c *UsageCounters) add(cpuSeconds float64, io ProcessIO) {
+	c.CPUSeconds += cpuSeconds
+	c.IO.RChar += io.RChar
+	c.IO.WChar += io.WChar
+	c.IO.SyscR += io.SyscR
+	c.IO.SyscW += io.SyscW
+	c.IO.ReadBytes += io.ReadBytes
+	c.IO.WriteBytes += io.WriteBytes
+	c.IO.CancelledWriteBytes += io.CancelledWriteBytes
+}
+
+// usageCounterSet keeps UsageCounters for every key, such as a user or a series. A key seen
+// for the first time starts from the lifetime usage of its processes, later collections only
+// add their growth, so the counters never drop when a process exits. Keys missing from a
+// collection are forgotten unless keep is set. A set is not safe for concurrent use; the
+// collector's sets are only used by Collect, the Prometheus sink's under its lock.
+type usageCounterSet[K comparable] struct {
+	keep    bool
+	totals  map[K]UsageCounters
+	current map[K]*UsageCounters
+}
+
+func newUsageCounterSet[K comparable](keep bool) *usageCounterSet[K] {
+	return &usageCounterSet[K]{keep: keep, totals: map[K]UsageCounters{}}
+}
+
+// begin starts a collection.
+func (s *usageCounterSet[K]) begin() {
+	s.current = map[K]*UsageCounters{}
+}
+
+// touch keeps key in the running collection even when none of its processes are in it, and
+// returns its counters.
+func (s *usageCounterSet[K]) touch(key K) *UsageCounters {
+	counters, ok := s.current[key]
+	if !ok {
+		counters = &UsageCounters{}
+		s.current[key] = counters
+		if total, seen := s.totals[key]; seen {
+			*counters = total
+		}
+	}
+	return counters
+}
+
+// add counts process p with its growth delta for key and returns the counters of key.
+func (s *usageCounterSet[K]) add(key K, p *Process, delta UsageDelta) *UsageCounters {
+	counters := s.touch(key)
+	if _, seen := s.totals[key]; !seen {
+		counters.add(p.CPUSeconds(), p.IO)
+		counters.HasIO = counters.HasIO || p.HasIO
+		return counters
+	}
+	counters.add(delta.CPUSeconds, delta.IO)
+	counters.HasIO = counters.HasIO || delta.HasIO
+	return counters
+}
+
+// get returns the counters of key in the running collection, or its totals when it has no
+// process in it.
+func (s *usageCounterSet[K]) get(key K) UsageCounters {
+	if counters, ok := s.current[key]; ok {
+		return *counters
+	}
+	return s.totals[key]
+}
+
+// end finishes a collection and makes its counters the totals the next one continues from.
+func (s *usageCounterSet[K]) end() {
+	totals := make(map[K]UsageCounters, len(s.current))
+	if s.keep {
+		for key, total := range s.totals {
+			totals[key] = total
+		}
+	}
+	for key, counters := range s.current {
+		totals[key] = *counters
+	}
+	s.totals = totals
+	s.current = nil
+}
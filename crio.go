@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const defaultCRIOSocket = "/var/run/crio/crio.sock"
+
+// crioResolver reads container metadata from the HTTP inspect endpoint CRI-O serves on its
+// unix socket next to the CRI gRPC API.
+type crioResolver struct {
+	socket string
+	client *http.Client
+}
+
+func newCRIOResolver(socket string) *crioResolver {
+	return &crioResolver{socket: socket, client: unixHTTPClient(socket, 5*time.Second)}
+}
+
+func (r *crioResolver) inspect(id string) (containerInfo, error) {
+	resp, err := r.client.Get("http://crio/containers/" + url.PathEscape(id))
+	if err != nil {
+		return containerInfo{}, fmt.Errorf("cannot inspect container %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return containerInfo{}, fmt.Errorf("%w: %s", errContainerNotFound, id)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return containerInfo{}, fmt.Errorf("cannot inspect container %s: %s", id, resp.Status)
+	}
+	var body struct {
+		Name   string            `json:"name"`
+		Image  string            `json:"image"`
+		Labels map[string]string `json:"labels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return containerInfo{}, fmt.Errorf("cannot decode container %s: %w", id, err)
+	}
+	name := body.Name
+	// CRI-O names containers k8s_<container>_<pod>_<namespace>_<uid>_<attempt>; the label holds the plain name
+	if container, ok := body.Labels["io.kubernetes.container.name"]; ok {
+		name = container
+	}
+	return containerInfo{ID: id, Name: name, Image: body.Image, Labels: body.Labels}, nil
+}
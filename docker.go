@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDockerSocket = "/var/run/docker.sock"
+	defaultPodmanSocket = "/run/podman/podman.sock"
+)
+
+// dockerResolver resolves container IDs to their metadata through the Docker Engine API on
+// a unix socket. Results are cached while the /events stream is connected, which evicts a
+// container when it is renamed or destroyed; without the stream every lookup asks the daemon.
+type dockerResolver struct {
+	socket string
+	client *http.Client
+	events *http.Client
+
+	mu        sync.Mutex
+	cache     map[string]containerInfo
+	connected bool
+	// generation counts the events and stream reconnects, so a lookup that raced with one
+	// does not cache what it read before.
+	generation uint64
+	watching   bool
+	cancel     context.CancelFunc
+	ctx        context.Context
+}
+
+func newDockerResolver(socket string) *dockerResolver {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &dockerResolver{
+		socket: socket,
+		client: unixHTTPClient(socket, 5*time.Second),
+		// The event stream stays open, so it has no overall timeout
+		events: unixHTTPClient(socket, 0),
+		cache:  map[string]containerInfo{},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Close stops watching the event stream.
+func (r *dockerResolver) Close() {
+	r.cancel()
+}
+
+// Resolve returns the metadata of the container with the given full ID.
+func (r *dockerResolver) Resolve(id string) (containerInfo, error) {
+	r.mu.Lock()
+	if info, ok := r.cache[id]; ok {
+		r.mu.Unlock()
+		return info, nil
+	}
+	if !r.watching {
+		r.watching = true
+		go r.watch()
+	}
+	generation := r.generation
+	r.mu.Unlock()
+
+	info, err := r.inspect(id)
+	if err != nil {
+		return info, err
+	}
+	r.mu.Lock()
+	if r.connected && r.generation == generation {
+		r.cache[id] = info
+	}
+	r.mu.Unlock()
+	return info, nil
+}
+
+func (r *dockerResolver) inspect(id string) (containerInfo, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, "http://docker/containers/"+url.PathEscape(id)+"/json", nil)
+	if err != nil {
+		return containerInfo{}, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return containerInfo{}, fmt.Errorf("cannot inspect container %s: %w", id, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return containerInfo{}, fmt.Errorf("%w: %s", errContainerNotFound, id)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return containerInfo{}, fmt.Errorf("cannot inspect container %s: %s", id, resp.Status)
+	}
+	var body struct {
+		ID     string `json:"Id"`
+		Name   string `json:"Name"`
+		Config struct {
+			Image  string            `json:"Image"`
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return containerInfo{}, fmt.Errorf("cannot decode container %s: %w", id, err)
+	}
+	return containerInfo{
+		ID: body.ID,
+		// The API reports names with a leading slash, docker ps shows them without
+		Name:   strings.TrimPrefix(body.Name, "/"),
+		Image:  body.Config.Image,
+		Labels: body.Config.Labels,
+	}, nil
+}
+
+// watch follows the event stream until the resolver is closed, reconnecting with a growing delay.
+func (r *dockerResolver) watch() {
+	delay := time.Second
+	for {
+		connectedAt := time.Now()
+		err := r.streamEvents()
+		if r.ctx.Err() != nil {
+			return
+		}
+		slog.Debug("Docker event stream closed", "socket", r.socket, "error", err)
+		if time.Since(connectedAt) > time.Minute {
+			delay = time.Second
+		}
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, time.Minute)
+	}
+}
+
+func (r *dockerResolver) streamEvents() error {
+	filters := url.QueryEscape(`{"type":["container"],"event":["rename","destroy"]}`)
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, "http://docker/events?filters="+filters, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.events.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot watch events: %s", resp.Status)
+	}
+
+	// Containers may have changed while the stream was down, so start over with an empty cache
+	r.mu.Lock()
+	clear(r.cache)
+	r.connected = true
+	r.generation++
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		clear(r.cache)
+		r.connected = false
+		r.generation++
+		r.mu.Unlock()
+	}()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Action string `json:"Action"`
+			Actor  struct {
+				ID string `json:"ID"`
+			} `json:"Actor"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+		slog.Debug("Docker container event", "action", event.Action, "container_id", event.Actor.ID)
+		r.mu.Lock()
+		delete(r.cache, event.Actor.ID)
+		r.generation++
+		r.mu.Unlock()
+	}
+}
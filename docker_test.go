@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDocker serves /containers/<id>/json and /events of the Docker Engine API on a unix socket.
+type fakeDocker struct {
+	server *httptest.Server
+	socket string
+	events chan string
+
+	mu       sync.Mutex
+	names    map[string]string
+	inspects int
+	// block holds the inspect of a container until it is closed
+	block map[string]chan struct{}
+}
+
+func newFakeDocker(t *testing.T) *fakeDocker {
+	t.Helper()
+	d := &fakeDocker{
+		socket: filepath.Join(t.TempDir(), "docker.sock"),
+		events: make(chan string),
+		names:  map[string]string{},
+		block:  map[string]chan struct{}{},
+	}
+	listener, err := net.Listen("unix", d.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/{id}/json", d.inspect)
+	mux.HandleFunc("/events", d.stream)
+	d.server = httptest.NewUnstartedServer(mux)
+	d.server.Listener = listener
+	d.server.Start()
+	t.Cleanup(d.server.Close)
+	return d
+}
+
+func (d *fakeDocker) inspect(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	d.mu.Lock()
+	d.inspects++
+	name, ok := d.names[id]
+	block := d.block[id]
+	d.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"Id": id, "Name": "/" + name, "Config": map[string]any{"Image": "nginx:latest"}})
+}
+
+func (d *fakeDocker) stream(w http.ResponseWriter, r *http.Request) {
+	var filters struct {
+		Event []string `json:"event"`
+	}
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil || strings.Join(filters.Event, ",") != "rename,destroy" {
+		http.Error(w, "unexpected filters", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-d.events:
+			action, id, _ := strings.Cut(event, " ")
+			json.NewEncoder(w).Encode(map[string]any{"Type": "container", "Action": action, "Actor": map[string]any{"ID": id}})
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func (d *fakeDocker) setName(id, name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if name == "" {
+		delete(d.names, id)
+	} else {
+		d.names[id] = name
+	}
+}
+
+func (d *fakeDocker) inspectCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inspects
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (r *dockerResolver) isCached(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.cache[id]
+	return ok
+}
+
+func (r *dockerResolver) isConnected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connected
+}
+
+func resolveName(t *testing.T, r *dockerResolver, id string) string {
+	t.Helper()
+	info, err := r.Resolve(id)
+	if err != nil {
+		t.Fatalf("Resolve(%s): %v", id, err)
+	}
+	return info.Name
+}
+
+func TestDockerResolverEvictsOnEvents(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.setName("abc", "web")
+	r := newDockerResolver(docker.socket)
+	defer r.Close()
+
+	// The first lookup starts watching the events; nothing is cached before the stream is up
+	if name := resolveName(t, r, "abc"); name != "web" {
+		t.Fatalf("name = %q, want web", name)
+	}
+	waitFor(t, "the event stream", r.isConnected)
+	resolveName(t, r, "abc")
+	before := docker.inspectCount()
+	if name := resolveName(t, r, "abc"); name != "web" || docker.inspectCount() != before {
+		t.Fatalf("cached lookup: name = %q, inspects %d -> %d", name, before, docker.inspectCount())
+	}
+
+	docker.setName("abc", "api")
+	docker.events <- "rename abc"
+	waitFor(t, "the rename to evict the container", func() bool { return !r.isCached("abc") })
+	if name := resolveName(t, r, "abc"); name != "api" {
+		t.Fatalf("name after rename = %q, want api", name)
+	}
+
+	docker.setName("abc", "")
+	docker.events <- "destroy abc"
+	waitFor(t, "the destroy to evict the container", func() bool { return !r.isCached("abc") })
+	if _, err := r.Resolve("abc"); !errors.Is(err, errContainerNotFound) {
+		t.Fatalf("Resolve after destroy: err = %v, want errContainerNotFound", err)
+	}
+}
+
+func TestDockerResolverRenameDuringInspect(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.setName("abc", "web")
+	r := newDockerResolver(docker.socket)
+	defer r.Close()
+	r.Resolve("other")
+	waitFor(t, "the event stream", r.isConnected)
+
+	// The rename is applied while the inspect that still read the old name is in flight
+	release := make(chan struct{})
+	docker.mu.Lock()
+	docker.block["abc"] = release
+	docker.mu.Unlock()
+	done := make(chan string)
+	go func() {
+		info, _ := r.Resolve("abc")
+		done <- info.Name
+	}()
+	waitFor(t, "the inspect", func() bool { return docker.inspectCount() == 2 })
+	r.mu.Lock()
+	generation := r.generation
+	r.mu.Unlock()
+	docker.setName("abc", "api")
+	docker.events <- "rename abc"
+	waitFor(t, "the rename", func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.generation > generation
+	})
+	close(release)
+	if name := <-done; name != "web" {
+		t.Fatalf("in-flight lookup = %q, want the old name web", name)
+	}
+	if r.isCached("abc") {
+		t.Fatal("the name read before the rename was cached")
+	}
+	if name := resolveName(t, r, "abc"); name != "api" {
+		t.Fatalf("name after rename = %q, want api", name)
+	}
+}
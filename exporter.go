@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// exporter wires a Collector to the sinks selected by the configuration and rebuilds them
+// when the configuration is reloaded. The HTTP listener is not part of it, so a reload
+// never drops the listener.
+type exporter struct {
+	mu        sync.Mutex
+	config    Config
+	collector *Collector
+	promSink  *prometheusSink
+	influx    *influxSink
+	cancel    context.CancelFunc
+	stopped   chan struct{}
+}
+
+func newExporter(config Config) *exporter {
+	e := &exporter{
+		config:    config,
+		collector: NewCollector(config.collectorOptions()),
+	}
+	if config.prometheusEnabled() {
+		e.promSink = newPrometheusSink(config.prometheusOptions())
+	}
+	if config.influxEnabled() {
+		e.setInflux(newInfluxSink(config.influxOptions()))
+	}
+	return e
+}
+
+func (e *exporter) setInflux(influx *influxSink) {
+	e.influx = influx
+	if e.promSink != nil {
+		e.promSink.Register(influx)
+	}
+}
+
+func (e *exporter) sinks() []Sink {
+	var sinks []Sink
+	if e.promSink != nil {
+		sinks = append(sinks, e.promSink)
+	}
+	if e.influx != nil {
+		sinks = append(sinks, e.influx)
+	}
+	return sinks
+}
+
+// start runs the collection loop in the background until stop is called.
+func (e *exporter) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	e.cancel = cancel
+	e.stopped = stopped
+	interval := e.config.Interval
+	sinks := e.sinks()
+	go func() {
+		defer close(stopped)
+		e.collector.Run(ctx, interval, sinks...)
+	}()
+}
+
+// stop cancels the collection loop and waits for a running collection to finish.
+func (e *exporter) stop() {
+	e.cancel()
+	<-e.stopped
+}
+
+// reload applies a new configuration. The listen address and the outputs decide whether
+// there is a listener at all, so changes to them are logged and only take effect after a restart.
+func (e *exporter) reload(config Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if config.Listen != e.config.Listen {
+		slog.Warn("Changing the listen address requires a restart", "listen", e.config.Listen, "configured", config.Listen)
+		config.Listen = e.config.Listen
+	}
+	if config.Output != e.config.Output {
+		slog.Warn("Changing the output requires a restart", "output", e.config.Output, "configured", config.Output)
+		config.Output = e.config.Output
+	}
+
+	e.stop()
+	e.collector.SetOptions(config.collectorOptions())
+	if e.promSink != nil {
+		e.promSink.SetOptions(config.prometheusOptions())
+	}
+	if e.influx != nil && config.influxOptions() != e.config.influxOptions() {
+		if e.promSink != nil {
+			e.promSink.Unregister(e.influx)
+		}
+		e.influx.Close()
+		e.setInflux(newInfluxSink(config.influxOptions()))
+	}
+	e.config = config
+	e.start()
+	slog.Info("Configuration reloaded", "interval", config.Interval, "output", config.Output)
+}
+
+// close stops collecting and flushes the points still buffered for InfluxDB.
+func (e *exporter) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stop()
+	if e.influx != nil {
+		e.influx.Close()
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// invalidUser replaces the user names that do not exist on the host in the failed login
+// series; brute-force attempts try a new name with nearly every guess.
+const invalidUser = "invalid"
+
+// maxFailedLoginSources is how many source addresses get their own failed login series. The
+// attempts from any address after that are counted with from set to otherSource, so a
+// distributed attack cannot grow the series without bound.
+const (
+	maxFailedLoginSources = 1000
+	otherSource           = "other"
+)
+
+// maxMissingUsers bounds the cache of user names that do not exist. Brute-force attempts
+// try a new name with nearly every guess, so the cache starts over once it is full.
+const maxMissingUsers = 10000
+
+// FailedLogin counts the failed login attempts for one user from one address with one method.
+type FailedLogin struct {
+	User string
+	// From is the source address, empty for local attempts.
+	From string
+	// Method is the sshd authentication method such as password or publickey, or ssh and
+	// login for the attempts recorded in btmp.
+	Method string
+	Count  uint64
+}
+
+type failedLoginKey struct {
+	user   string
+	from   string
+	method string
+}
+
+// sshdFailedRE matches the sshd messages for failed authentication attempts:
+//
+//	Failed password for invalid user admin from 203.0.113.7 port 50022 ssh2
+//	Failed publickey for root from 2001:db8::7 port 50022 ssh2: ED25519 SHA256:...
+//
+// The user name is chosen by the client and may itself contain " from <address> port <n>",
+// so the match is anchored at the end and the greedy name leaves the last address to from.
+var sshdFailedRE = regexp.MustCompile(`^Failed (\S+) for (invalid user )?(.*) from (\S+) port \d+(?: ssh2(?:: .*)?)?$`)
+
+// sshdSyslogRE matches the sshd lines of a syslog file. OpenSSH 9.8 and later log as sshd-session.
+var sshdSyslogRE = regexp.MustCompile(`\ssshd(-session)?\[\d+\]: `)
+
+// syslogRepeatedRE matches the rsyslog summary of a repeated message.
+var syslogRepeatedRE = regexp.MustCompile(`message repeated (\d+) times: \[ ?(.*?) ?\]$`)
+
+// failedLoginTracker counts the failed logins in btmp, a syslog file and the systemd journal.
+// Each source is read from where the previous collection stopped; the first collection only
+// skips to the end unless Replay counts what is already logged. The sources do not know of
+// each other, so an sshd attempt is counted twice when both btmp and a log are read.
+type failedLoginTracker struct {
+	mu      sync.Mutex
+	options FailedLoginsConfig
+	started bool
+	since   time.Time
+
+	btmp    fileTail
+	authLog fileTail
+	cursor  string
+
+	counts map[failedLoginKey]uint64
+	// sources are the addresses that have their own series, see maxFailedLoginSources.
+	sources map[string]bool
+	// users and missing cache the user names that exist and those that do not.
+	users   map[string]bool
+	missing map[string]bool
+}
+
+func newFailedLoginTracker(options FailedLoginsConfig) *failedLoginTracker {
+	return &failedLoginTracker{
+		options: options,
+		since:   time.Now(),
+		btmp:    fileTail{path: options.Btmp},
+		authLog: fileTail{path: options.AuthLog},
+		counts:  map[failedLoginKey]uint64{},
+		sources: map[string]bool{},
+		users:   map[string]bool{},
+		missing: map[string]bool{},
+	}
+}
+
+// skipping reports whether the logs are read for the first time and what is already in
+// them is not counted.
+func (t *failedLoginTracker) skipping() bool {
+	return !t.started && !t.options.Replay
+}
+
+func (t *failedLoginTracker) userExists(name string) bool {
+	if t.users[name] {
+		return true
+	}
+	if t.missing[name] {
+		return false
+	}
+	if _, err := user.Lookup(name); err == nil {
+		t.users[name] = true
+		return true
+	}
+	if len(t.missing) >= maxMissingUsers {
+		clear(t.missing)
+	}
+	t.missing[name] = true
+	return false
+}
+
+func (t *failedLoginTracker) add(name, from, method string, n uint64, invalid bool) {
+	if invalid || !t.userExists(name) {
+		name = invalidUser
+	}
+	if from != "" && !t.sources[from] {
+		if len(t.sources) < maxFailedLoginSources {
+			t.sources[from] = true
+		} else {
+			if !t.sources[otherSource] {
+				slog.Warn("Too many failed login sources, counting the new ones as other", "max", maxFailedLoginSources)
+				t.sources[otherSource] = true
+			}
+			from = otherSource
+		}
+	}
+	t.counts[failedLoginKey{user: name, from: from, method: method}] += n
+}
+
+// update reads the failed logins since the previous collection and returns the totals.
+func (t *failedLoginTracker) update() []FailedLogin {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.options.Btmp != "" {
+		var err error
+		if t.skipping() {
+			err = t.btmp.skipToEnd(int64(utmpRecordSize))
+		} else {
+			err = t.readBtmp()
+		}
+		if err != nil {
+			slog.Warn("Cannot read btmp", "path", t.options.Btmp, "error", err)
+		}
+	}
+	if t.options.AuthLog != "" {
+		var err error
+		if t.skipping() {
+			err = t.authLog.skipToEnd(1)
+		} else {
+			err = t.authLog.read(func(data []byte) int {
+				return t.readAuthLog(data)
+			})
+		}
+		if err != nil {
+			slog.Warn("Cannot read auth log", "path", t.options.AuthLog, "error", err)
+		}
+	}
+	if t.options.Journal {
+		if err := t.readJournal(); err != nil {
+			slog.Warn("Cannot read the journal", "error", err)
+		}
+	}
+	t.started = true
+
+	logins := make([]FailedLogin, 0, len(t.counts))
+	for key, n := range t.counts {
+		logins = append(logins, FailedLogin{User: key.user, From: key.from, Method: key.method, Count: n})
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		a, b := logins[i], logins[j]
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.Method < b.Method
+	})
+	return logins
+}
+
+func (t *failedLoginTracker) readBtmp() error {
+	var records []Session
+	var readErr error
+	err := t.btmp.read(func(data []byte) int {
+		records, readErr = readUtmpRecords(bytes.NewReader(data))
+		return len(records) * utmpRecordSize
+	})
+	if err := errors.Join(err, readErr); err != nil {
+		return err
+	}
+	for _, r := range records {
+		method := "login"
+		if strings.HasPrefix(r.TTY, "ssh:") {
+			method = "ssh"
+		}
+		from := r.Host
+		if r.Addr != nil {
+			from = r.Addr.String()
+		}
+		t.add(r.User, from, method, 1, false)
+	}
+	return nil
+}
+
+// readAuthLog counts the sshd failures in the complete lines of a syslog file, such as
+// /var/log/auth.log or /var/log/secure, and returns the number of bytes it read.
+func (t *failedLoginTracker) readAuthLog(data []byte) int {
+	end := bytes.LastIndexByte(data, '\n') + 1
+	scanner := bufio.NewScanner(bytes.NewReader(data[:end]))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if loc := sshdSyslogRE.FindStringIndex(line); loc != nil {
+			t.sshdMessage(line[loc[1]:])
+		}
+	}
+	return end
+}
+
+// sshdMessage counts the failed login described by an sshd log message, if any.
+func (t *failedLoginTracker) sshdMessage(message string) {
+	n := uint64(1)
+	if m := syslogRepeatedRE.FindStringSubmatch(message); m != nil {
+		n, _ = strconv.ParseUint(m[1], 10, 64)
+		message = m[2]
+	}
+	m := sshdFailedRE.FindStringSubmatch(message)
+	if m == nil {
+		return
+	}
+	t.add(m[3], m[4], m[1], n, m[2] != "")
+}
+
+// readJournal asks journalctl for the sshd messages logged since the previous call, or
+// on the first call since the tracker was created.
+func (t *failedLoginTracker) readJournal() error {
+	args := []string{"--output=export", "--no-pager", "SYSLOG_IDENTIFIER=sshd", "SYSLOG_IDENTIFIER=sshd-session"}
+	switch {
+	case t.cursor != "":
+		args = append(args, "--after-cursor="+t.cursor)
+	case !t.options.Replay:
+		args = append(args, fmt.Sprintf("--since=@%d", t.since.Unix()))
+	}
+	out, err := exec.Command("journalctl", args...).Output()
+	if err != nil {
+		return fmt.Errorf("journalctl failed: %w", err)
+	}
+	return parseJournalExport(bytes.NewReader(out), func(fields map[string]string) {
+		t.sshdMessage(fields["MESSAGE"])
+		if cursor, ok := fields["__CURSOR"]; ok {
+			t.cursor = cursor
+		}
+	})
+}
+
+// parseJournalExport parses the journal export format, calling entry with the fields of
+// every entry. Fields are "KEY=value" lines, or for binary values the key on its own line
+// followed by the little-endian 64-bit size, the data and a newline. Entries are separated
+// by an empty line.
+func parseJournalExport(r io.Reader, entry func(fields map[string]string)) error {
+	br := bufio.NewReader(r)
+	fields := map[string]string{}
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			if len(fields) > 0 {
+				entry(fields)
+			}
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(fields) > 0 {
+				entry(fields)
+				fields = map[string]string{}
+			}
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			fields[key] = value
+			continue
+		}
+		var size uint64
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return fmt.Errorf("field %s: %w", line, err)
+		}
+		if size > 1<<24 {
+			return fmt.Errorf("field %s: size %d too large", line, size)
+		}
+		value := make([]byte, size+1)
+		if _, err := io.ReadFull(br, value); err != nil {
+			return fmt.Errorf("field %s: %w", line, err)
+		}
+		fields[line] = string(value[:size])
+	}
+}
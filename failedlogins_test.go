@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFailedLoginTracker returns a tracker that knows the users root and alice.
+func newTestFailedLoginTracker(options FailedLoginsConfig) *failedLoginTracker {
+	t := newFailedLoginTracker(options)
+	t.users["root"] = true
+	t.users["alice"] = true
+	return t
+}
+
+func TestSSHDMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []FailedLogin
+	}{
+		{
+			name:    "password",
+			message: "Failed password for root from 203.0.113.7 port 50022 ssh2",
+			want:    []FailedLogin{{User: "root", From: "203.0.113.7", Method: "password", Count: 1}},
+		},
+		{
+			name:    "invalid user",
+			message: "Failed password for invalid user admin from 203.0.113.7 port 50022 ssh2",
+			want:    []FailedLogin{{User: invalidUser, From: "203.0.113.7", Method: "password", Count: 1}},
+		},
+		{
+			name:    "public key with IPv6",
+			message: "Failed publickey for alice from 2001:db8::7 port 50022 ssh2: ED25519 SHA256:Zm9vYmFy",
+			want:    []FailedLogin{{User: "alice", From: "2001:db8::7", Method: "publickey", Count: 1}},
+		},
+		{
+			name:    "user name that does not exist",
+			message: "Failed keyboard-interactive/pam for no-such-user-4711 from 203.0.113.7 port 50022 ssh2",
+			want:    []FailedLogin{{User: invalidUser, From: "203.0.113.7", Method: "keyboard-interactive/pam", Count: 1}},
+		},
+		{
+			name:    "user name with a spoofed address",
+			message: "Failed password for invalid user x from 198.51.100.9 port 1 from 203.0.113.7 port 50022 ssh2",
+			want:    []FailedLogin{{User: invalidUser, From: "203.0.113.7", Method: "password", Count: 1}},
+		},
+		{
+			name:    "user name with a spoofed address and trailer",
+			message: "Failed password for invalid user x from 198.51.100.9 port 1 ssh2: y from 203.0.113.7 port 50022 ssh2",
+			want:    []FailedLogin{{User: invalidUser, From: "203.0.113.7", Method: "password", Count: 1}},
+		},
+		{
+			name:    "repeated",
+			message: "message repeated 3 times: [ Failed password for root from 203.0.113.7 port 50022 ssh2]",
+			want:    []FailedLogin{{User: "root", From: "203.0.113.7", Method: "password", Count: 3}},
+		},
+		{
+			name:    "not a failure",
+			message: "Accepted publickey for alice from 203.0.113.7 port 50022 ssh2: ED25519 SHA256:Zm9vYmFy",
+		},
+		{
+			name:    "failure inside another message",
+			message: "Connection closed by authenticating user root 203.0.113.7 port 50022: Failed password for root from 198.51.100.9 port 1 ssh2 [preauth]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newTestFailedLoginTracker(FailedLoginsConfig{})
+			tracker.sshdMessage(tt.message)
+			if got := tracker.update(); !reflect.DeepEqual(got, tt.want) && len(got)+len(tt.want) > 0 {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJournalExport(t *testing.T) {
+	binaryField := func(key, value string) string {
+		size := make([]byte, 8)
+		binary.LittleEndian.PutUint64(size, uint64(len(value)))
+		return key + "\n" + string(size) + value + "\n"
+	}
+	tests := []struct {
+		name    string
+		data    string
+		want    []map[string]string
+		wantErr string
+	}{
+		{
+			name: "text fields",
+			data: "__CURSOR=s=1\nSYSLOG_IDENTIFIER=sshd\nMESSAGE=Failed password for root from 203.0.113.7 port 50022 ssh2\n\n" +
+				"__CURSOR=s=2\nMESSAGE=a=b\n\n",
+			want: []map[string]string{
+				{"__CURSOR": "s=1", "SYSLOG_IDENTIFIER": "sshd", "MESSAGE": "Failed password for root from 203.0.113.7 port 50022 ssh2"},
+				{"__CURSOR": "s=2", "MESSAGE": "a=b"},
+			},
+		},
+		{
+			name: "binary field",
+			data: "__CURSOR=s=1\n" + binaryField("MESSAGE", "two\nlines=\x00") + "PRIORITY=5\n\n",
+			want: []map[string]string{{"__CURSOR": "s=1", "MESSAGE": "two\nlines=\x00", "PRIORITY": "5"}},
+		},
+		{
+			name: "last entry without an empty line",
+			data: "MESSAGE=one\n\n\nMESSAGE=two\n",
+			want: []map[string]string{{"MESSAGE": "one"}, {"MESSAGE": "two"}},
+		},
+		{
+			name: "empty",
+		},
+		{
+			name:    "truncated binary field",
+			data:    "MESSAGE=one\n\n" + binaryField("MESSAGE", "two")[:12],
+			want:    []map[string]string{{"MESSAGE": "one"}},
+			wantErr: "field MESSAGE:",
+		},
+		{
+			name:    "binary field too large",
+			data:    "MESSAGE\n\xff\xff\xff\xff\x00\x00\x00\x00",
+			wantErr: "too large",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []map[string]string
+			err := parseJournalExport(strings.NewReader(tt.data), func(fields map[string]string) {
+				got = append(got, fields)
+			})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func appendFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadAuthLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, []byte(
+		"May  1 08:30:15 host sshd[812]: Failed password for root from 203.0.113.7 port 50022 ssh2\n"+
+			"May  1 08:30:16 host sshd-session[813]: Failed password for invalid user admin from 203.0.113.7 port 50023 ssh2\n"+
+			"May  1 08:30:17 host sshd[812]: message repeated 4 times: [ Failed password for root from 203.0.113.7 port 50022 ssh2]\n"+
+			"May  1 08:30:18 host sshd[814]: Accepted publickey for alice from 192.0.2.10 port 50024 ssh2: ED25519 SHA256:Zm9vYmFy\n"+
+			"May  1 08:30:19 host su[815]: Failed password for root from 203.0.113.7 port 1 ssh2\n"+
+			// The last line is still being written
+			"May  1 08:30:20 host sshd[816]: Failed publickey for alice from 2001:db8::7 port"))
+	tracker := newTestFailedLoginTracker(FailedLoginsConfig{AuthLog: path, Replay: true})
+	want := []FailedLogin{
+		{User: invalidUser, From: "203.0.113.7", Method: "password", Count: 1},
+		{User: "root", From: "203.0.113.7", Method: "password", Count: 5},
+	}
+	if got := tracker.update(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	appendFile(t, path, []byte(" 50025 ssh2\n"))
+	want = append([]FailedLogin{{User: "alice", From: "2001:db8::7", Method: "publickey", Count: 1}}, want...)
+	if got := tracker.update(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after the line was completed: got %+v, want %+v", got, want)
+	}
+
+	// Without replay, what is already logged is not counted
+	tracker = newTestFailedLoginTracker(FailedLoginsConfig{AuthLog: path})
+	if got := tracker.update(); len(got) != 0 {
+		t.Fatalf("first update without replay: %+v", got)
+	}
+	appendFile(t, path, []byte("May  1 08:31:00 host sshd[817]: Failed password for root from 198.51.100.9 port 50026 ssh2\n"))
+	want = []FailedLogin{{User: "root", From: "198.51.100.9", Method: "password", Count: 1}}
+	if got := tracker.update(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("without replay: got %+v, want %+v", got, want)
+	}
+}
+
+func TestReadBtmp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btmp")
+	login := time.Unix(1714552215, 0)
+	appendFile(t, path, writeUtmpRecords(t,
+		testUtmpRecord(utLoginProcess, 812, "ssh:notty", "root", "203.0.113.7", login, net.ParseIP("203.0.113.7")),
+		testUtmpRecord(utLoginProcess, 813, "ssh:notty", "no-such-user-4711", "2001:db8::7", login, net.ParseIP("2001:db8::7")),
+		testUtmpRecord(utLoginProcess, 814, "tty1", "alice", "", login, nil),
+		// sshd falls back to the host name when it has no address
+		testUtmpRecord(utLoginProcess, 815, "ssh:notty", "alice", "gw.example.com", login, nil),
+	))
+	tracker := newTestFailedLoginTracker(FailedLoginsConfig{Btmp: path, Replay: true})
+	want := []FailedLogin{
+		{User: "alice", From: "", Method: "login", Count: 1},
+		{User: "alice", From: "gw.example.com", Method: "ssh", Count: 1},
+		{User: invalidUser, From: "2001:db8::7", Method: "ssh", Count: 1},
+		{User: "root", From: "203.0.113.7", Method: "ssh", Count: 1},
+	}
+	if got := tracker.update(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// A record that is still being written is read once it is complete
+	record := writeUtmpRecords(t, testUtmpRecord(utLoginProcess, 816, "ssh:notty", "root", "203.0.113.7", login, net.ParseIP("203.0.113.7")))
+	appendFile(t, path, record[:100])
+	if got := tracker.update(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after half a record: got %+v, want %+v", got, want)
+	}
+	appendFile(t, path, record[100:])
+	want[3].Count = 2
+	if got := tracker.update(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after the record was completed: got %+v, want %+v", got, want)
+	}
+}
+
+func TestFailedLoginSourceLimit(t *testing.T) {
+	tracker := newTestFailedLoginTracker(FailedLoginsConfig{})
+	for i := range maxFailedLoginSources + 10 {
+		tracker.add("root", fmt.Sprintf("10.0.%d.%d", i/256, i%256), "password", 1, false)
+	}
+	// The sources that have their own series keep it
+	tracker.add("root", "10.0.0.0", "password", 1, false)
+	tracker.add("root", "", "login", 1, false)
+
+	counts := map[string]uint64{}
+	for _, l := range tracker.update() {
+		counts[l.From] += l.Count
+	}
+	if len(counts) != maxFailedLoginSources+2 {
+		t.Errorf("%d sources, want %d, other and the local one", len(counts), maxFailedLoginSources+2)
+	}
+	if counts[otherSource] != 10 || counts["10.0.0.0"] != 2 || counts[""] != 1 {
+		t.Errorf("other = %d, 10.0.0.0 = %d, local = %d, want 10, 2 and 1", counts[otherSource], counts["10.0.0.0"], counts[""])
+	}
+	if tracker.sources["10.0.3.240"] {
+		t.Error("a source over the limit got its own series")
+	}
+}
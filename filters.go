@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+)
+
+// Rankings selectable with filters.top.by.
+const (
+	rankByCPU = "cpu"
+	rankByRSS = "rss"
+	rankByIO  = "io"
+)
+
+// processFilter is the compiled form of the process filters of FiltersConfig. It decides
+// which processes get their own series; the per-user totals include every process of the
+// users that filters.users keeps, whatever these filters say.
+type processFilter struct {
+	allow                []*regexp.Regexp
+	deny                 []*regexp.Regexp
+	excludeKernelThreads bool
+	min                  ThresholdsConfig
+	top                  TopConfig
+}
+
+func compileRegexps(key string, patterns []string) ([]*regexp.Regexp, error) {
+	var errs []error
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		res = append(res, re)
+	}
+	return res, errors.Join(errs...)
+}
+
+func newProcessFilter(config FiltersConfig) (*processFilter, error) {
+	allow, allowErr := compileRegexps("filters.commands.allow", config.Commands.Allow)
+	deny, denyErr := compileRegexps("filters.commands.deny", config.Commands.Deny)
+	errs := []error{allowErr, denyErr}
+	if config.Top.N < 0 {
+		errs = append(errs, fmt.Errorf("filters.top.n: must not be negative, got %d", config.Top.N))
+	}
+	if !slices.Contains([]string{rankByCPU, rankByRSS, rankByIO}, config.Top.By) {
+		errs = append(errs, fmt.Errorf("filters.top.by: unknown ranking %q, valid rankings are %s, %s and %s", config.Top.By, rankByCPU, rankByRSS, rankByIO))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &processFilter{
+		allow:                allow,
+		deny:                 deny,
+		excludeKernelThreads: config.ExcludeKernelThreads,
+		min:                  config.Min,
+		top:                  config.Top,
+	}, nil
+}
+
+// match reports whether a process passes the command, kernel thread and threshold filters.
+func (f *processFilter) match(p *ProcessSample) bool {
+	if f.excludeKernelThreads && p.IsKernelThread() {
+		return false
+	}
+	command := p.Command()
+	if len(f.allow) > 0 && !slices.ContainsFunc(f.allow, func(re *regexp.Regexp) bool { return re.MatchString(command) }) {
+		return false
+	}
+	if slices.ContainsFunc(f.deny, func(re *regexp.Regexp) bool { return re.MatchString(command) }) {
+		return false
+	}
+	return cpuPercent(p) >= f.min.CPUPercent &&
+		p.RSS >= f.min.RSSBytes &&
+		ioBytesPerSec(p) >= f.min.IOBytesPerSec
+}
+
+// cpuPercent is the CPU time the process used since the previous collection in percent of
+// one CPU, 0 when it is new, so a process that is busy now outranks one that was busy once.
+func cpuPercent(p *ProcessSample) float64 {
+	if p.Delta.Elapsed <= 0 {
+		return 0
+	}
+	return p.Delta.CPUSeconds / p.Delta.Elapsed.Seconds() * 100
+}
+
+func ioBytesPerSec(p *ProcessSample) float64 {
+	if !p.HasIORate {
+		return 0
+	}
+	return p.IORate.ReadBytesPerSec + p.IORate.WriteBytesPerSec
+}
+
+func (f *processFilter) rank(p *ProcessSample) float64 {
+	switch f.top.By {
+	case rankByCPU:
+		return cpuPercent(p)
+	case rankByIO:
+		return ioBytesPerSec(p)
+	}
+	return float64(p.RSS)
+}
+
+// apply returns the processes that pass the filters. With filters.top.n set only the
+// top N of them by the configured ranking are kept, highest first.
+func (f *processFilter) apply(samples []ProcessSample) []ProcessSample {
+	kept := make([]ProcessSample, 0, len(samples))
+	for i := range samples {
+		if f.match(&samples[i]) {
+			kept = append(kept, samples[i])
+		}
+	}
+	if f.top.N == 0 || len(kept) <= f.top.N {
+		return kept
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return f.rank(&kept[i]) > f.rank(&kept[j]) })
+	return kept[:f.top.N]
+}
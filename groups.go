@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// GroupUsage is the combined usage of the processes of one process group. CPUSeconds,
+// IOReadBytes and IOWriteBytes keep what exited processes used, so they only go up
+// while the group rules stay the same and the group does not go idle, see groupRetention.
+type GroupUsage struct {
+	Group        string
+	Processes    int
+	Threads      int
+	CPUSeconds   float64
+	RSS          uint64
+	VSZ          uint64
+	IOReadBytes  uint64
+	IOWriteBytes uint64
+	// OpenFDs only includes the processes whose fd directory could be read.
+	OpenFDs int
+}
+
+// groupNameData is what the name template of a group rule is executed with.
+type groupNameData struct {
+	Comm     string
+	ExeBase  string
+	ExeFull  string
+	Username string
+	Cgroup   string
+	// Matches are the named captures of the cmdline expressions.
+	Matches map[string]string
+}
+
+type groupRule struct {
+	name    *template.Template
+	comm    []string
+	exe     []string
+	cmdline []*regexp.Regexp
+	cgroup  []*regexp.Regexp
+}
+
+// processGrouper is the compiled form of the group rules of the configuration.
+type processGrouper struct {
+	rules []groupRule
+}
+
+func newProcessGrouper(configs []GroupConfig) (*processGrouper, error) {
+	var errs []error
+	g := &processGrouper{}
+	for i, config := range configs {
+		key := fmt.Sprintf("groups[%d]", i)
+		if len(config.Comm) == 0 && len(config.Exe) == 0 && len(config.Cmdline) == 0 && len(config.Cgroup) == 0 {
+			errs = append(errs, fmt.Errorf("%s: needs at least one of comm, exe, cmdline and cgroup", key))
+		}
+		if config.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: must not be empty", key))
+		}
+		name, err := template.New(key).Option("missingkey=zero").Parse(config.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.name: %w", key, err))
+		}
+		cmdline, cmdlineErr := compileRegexps(key+".cmdline", config.Cmdline)
+		cgroup, cgroupErr := compileRegexps(key+".cgroup", config.Cgroup)
+		errs = append(errs, cmdlineErr, cgroupErr)
+		g.rules = append(g.rules, groupRule{name: name, comm: config.Comm, exe: config.Exe, cmdline: cmdline, cgroup: cgroup})
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// needsCgroup reports whether a rule matches on the cgroup, which then has to be read
+// for every process before grouping.
+func (g *processGrouper) needsCgroup() bool {
+	return slices.ContainsFunc(g.rules, func(rule groupRule) bool { return len(rule.cgroup) > 0 })
+}
+
+// group returns the group of a process, false when no rule matches it.
+func (g *processGrouper) group(p *ProcessSample) (string, bool) {
+	for _, rule := range g.rules {
+		data, ok := rule.match(p)
+		if !ok {
+			continue
+		}
+		var name strings.Builder
+		if err := rule.name.Execute(&name, data); err != nil || name.Len() == 0 {
+			continue
+		}
+		return name.String(), true
+	}
+	return "", false
+}
+
+func (rule *groupRule) match(p *ProcessSample) (groupNameData, bool) {
+	data := groupNameData{
+		Comm:     p.Comm,
+		ExeBase:  filepath.Base(p.Exe),
+		ExeFull:  p.Exe,
+		Username: p.User,
+		Cgroup:   p.Cgroup,
+	}
+	if p.Exe == "" && len(p.Cmdline) > 0 {
+		// Another user's exe link needs root, argv[0] is the next best thing
+		data.ExeBase, data.ExeFull = filepath.Base(p.Cmdline[0]), p.Cmdline[0]
+	}
+	if len(rule.comm) > 0 && !slices.Contains(rule.comm, p.Comm) {
+		return data, false
+	}
+	if len(rule.exe) > 0 && !slices.ContainsFunc(rule.exe, func(exe string) bool {
+		if strings.Contains(exe, "/") {
+			return exe == data.ExeFull
+		}
+		return exe == data.ExeBase
+	}) {
+		return data, false
+	}
+	if len(rule.cgroup) > 0 && !slices.ContainsFunc(rule.cgroup, func(re *regexp.Regexp) bool { return re.MatchString(p.Cgroup) }) {
+		return data, false
+	}
+	command := p.Command()
+	for _, re := range rule.cmdline {
+		match := re.FindStringSubmatch(command)
+		if match == nil {
+			return data, false
+		}
+		for i, name := range re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			if data.Matches == nil {
+				data.Matches = map[string]string{}
+			}
+			data.Matches[name] = match[i]
+		}
+	}
+	return data, true
+}
+
+// groupRetention is how long a group without processes keeps its series. Templated names
+// such as {{.Comm}} create a group for every command ever run, so idle groups are dropped;
+// a group that comes back starts its counters again.
+const groupRetention = time.Hour
+
+// groupTracker turns the usage of the processes of each group into counters that keep
+// what exited processes used, see usageCounterSet.
+type groupTracker struct {
+	mu       sync.Mutex
+	counters *usageCounterSet[string]
+	// lastSeen is when each group last had a process.
+	lastSeen map[string]time.Time
+}
+
+func newGroupTracker() *groupTracker {
+	return &groupTracker{counters: newUsageCounterSet[string](false), lastSeen: map[string]time.Time{}}
+}
+
+// update groups the processes and returns the usage of every group that had a process
+// within groupRetention, sorted by name.
+func (t *groupTracker) update(grouper *processGrouper, processes []ProcessSample, now time.Time) []GroupUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	byGroup := map[string]*GroupUsage{}
+	t.counters.begin()
+	for i := range processes {
+		p := &processes[i]
+		group, ok := grouper.group(p)
+		if !ok {
+			continue
+		}
+		usage, ok := byGroup[group]
+		if !ok {
+			usage = &GroupUsage{Group: group}
+			byGroup[group] = usage
+		}
+		usage.Processes++
+		usage.Threads += p.Threads
+		usage.RSS += p.RSS
+		usage.VSZ += p.VSZ
+		if p.HasFDs {
+			usage.OpenFDs += p.FDs
+		}
+		t.counters.add(group, &p.Process, p.Delta)
+		t.lastSeen[group] = now
+	}
+
+	groups := make([]GroupUsage, 0, len(t.lastSeen))
+	for group, seen := range t.lastSeen {
+		if now.Sub(seen) > groupRetention {
+			delete(t.lastSeen, group)
+			continue
+		}
+		usage, ok := byGroup[group]
+		if !ok {
+			usage = &GroupUsage{Group: group}
+		}
+		total := *t.counters.touch(group)
+		usage.CPUSeconds = total.CPUSeconds
+		usage.IOReadBytes = total.IO.ReadBytes
+		usage.IOWriteBytes = total.IO.WriteBytes
+		groups = append(groups, *usage)
+	}
+	t.counters.end()
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Group < groups[j].Group })
+	return groups
+}
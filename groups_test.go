@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupTracker(t *testing.T) {
+	grouper, err := newProcessGrouper([]GroupConfig{{Name: "{{.Comm}}", Comm: []string{"cc1", "ld"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := newGroupTracker()
+	deltas := newUsageDeltaTracker()
+	start := time.Unix(1000, 0)
+	now := start.Add(time.Hour)
+	update := func(processes ...Process) map[string]GroupUsage {
+		t.Helper()
+		delta := deltas.update(processes, now)
+		samples := make([]ProcessSample, 0, len(processes))
+		for _, p := range processes {
+			samples = append(samples, ProcessSample{Process: p, Delta: delta[p.PID]})
+		}
+		groups := map[string]GroupUsage{}
+		for _, g := range tracker.update(grouper, samples, now) {
+			groups[g.Group] = g
+		}
+		now = now.Add(time.Minute)
+		return groups
+	}
+	cc1 := func(pid int, ticks uint64) Process {
+		return Process{PID: pid, Comm: "cc1", StartTime: start, UTime: ticks, RSS: 10, HasIO: true, IO: ProcessIO{ReadBytes: ticks}}
+	}
+	ticks := uint64(clockTicks)
+
+	groups := update(cc1(1, 2*ticks), cc1(2, ticks), Process{PID: 3, Comm: "bash", StartTime: start})
+	if g := groups["cc1"]; g.Processes != 2 || g.CPUSeconds != 3 || g.RSS != 20 || g.IOReadBytes != 3*ticks {
+		t.Fatalf("first collection: %+v", g)
+	}
+	if _, ok := groups["bash"]; ok {
+		t.Fatal("a process no rule matches was grouped")
+	}
+
+	// Process 2 exits and process 4 starts with what it used so far
+	groups = update(cc1(1, 3*ticks), cc1(4, ticks))
+	if g := groups["cc1"]; g.Processes != 2 || g.CPUSeconds != 5 || g.IOReadBytes != 5*ticks {
+		t.Fatalf("after an exit: %+v", g)
+	}
+
+	// The group keeps its counters while idle, until groupRetention has passed
+	groups = update()
+	if g, ok := groups["cc1"]; !ok || g.Processes != 0 || g.CPUSeconds != 5 {
+		t.Fatalf("idle group: %+v, %v", g, ok)
+	}
+	now = now.Add(groupRetention)
+	if groups = update(); len(groups) != 0 {
+		t.Fatalf("groups after the retention: %+v", groups)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ttyIdle returns how long ago the terminal of a session was last read from, the idle
+// time w shows. X displays such as :0 have no device and no idle time.
+func ttyIdle(tty string, now time.Time) (time.Duration, bool) {
+	if tty == "" || strings.Contains(tty, ":") {
+		return 0, false
+	}
+	info, err := os.Stat(filepath.Join("/dev", tty))
+	if err != nil {
+		return 0, false
+	}
+	atime, ok := fileAtime(info)
+	if !ok {
+		return 0, false
+	}
+	return max(now.Sub(atime), 0), true
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileAtime returns the last access time of a file, which the kernel updates on a
+// terminal whenever it is read from.
+func fileAtime(info os.FileInfo) (time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(stat.Atim.Sec, stat.Atim.Nsec), true
+}
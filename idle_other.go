@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileAtime is not implemented outside Linux, so sessions have no idle time there.
+func fileAtime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
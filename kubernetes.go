@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultKubeletURL = "http://127.0.0.1:10255"
+
+// Kubernetes QoS classes, the cgroup a pod is placed in depends on it.
+const (
+	qosGuaranteed = "guaranteed"
+	qosBurstable  = "burstable"
+	qosBestEffort = "besteffort"
+)
+
+// kubernetesPod is the pod a process runs in. UID and QoS come from the cgroup path, the
+// names from the kubelet or the labels the container runtime keeps.
+type kubernetesPod struct {
+	UID       string
+	QoS       string
+	Name      string
+	Namespace string
+	// Container is the name of the container in the pod spec.
+	Container string
+}
+
+// parseKubepodsPath recognises the cgroup paths kubelet creates for pods, with the systemd
+// driver (/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid>.slice/...)
+// or the cgroupfs driver (/kubepods/burstable/pod<uid>/<container id>). containerID is only
+// set for the cgroupfs driver, the systemd scopes are left to the runtime classifiers.
+func parseKubepodsPath(cgroupPath string) (pod kubernetesPod, containerID string, ok bool) {
+	components := strings.Split(strings.Trim(cgroupPath, "/"), "/")
+	start := -1
+	for i, component := range components {
+		if component == "kubepods" || component == "kubepods.slice" {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return pod, "", false
+	}
+	pod.QoS = qosGuaranteed
+	for i := start + 1; i < len(components); i++ {
+		component := strings.TrimSuffix(components[i], ".slice")
+		component = strings.TrimPrefix(component, "kubepods-")
+		switch {
+		case component == qosBurstable || component == qosBestEffort:
+			pod.QoS = component
+		case strings.HasPrefix(component, qosBurstable+"-pod") || strings.HasPrefix(component, qosBestEffort+"-pod") || strings.HasPrefix(component, "pod"):
+			_, uid, _ := strings.Cut(component, "pod")
+			// The systemd driver escapes the dashes of the UID
+			pod.UID = strings.ReplaceAll(uid, "_", "-")
+			if i+1 < len(components) && isContainerID(components[i+1]) {
+				containerID = components[i+1]
+			}
+			return pod, containerID, pod.UID != ""
+		}
+	}
+	return pod, "", false
+}
+
+// podFromLabels fills the names that are still missing from the labels Docker and CRI-O
+// put on containers, or the annotations of containerd's CRI plugin.
+func podFromLabels(pod *kubernetesPod, labels map[string]string) {
+	fill := func(field *string, keys ...string) {
+		for _, key := range keys {
+			if *field == "" {
+				*field = labels[key]
+			}
+		}
+	}
+	fill(&pod.Name, "io.kubernetes.pod.name", "io.kubernetes.cri.sandbox-name")
+	fill(&pod.Namespace, "io.kubernetes.pod.namespace", "io.kubernetes.cri.sandbox-namespace")
+	fill(&pod.Container, "io.kubernetes.container.name", "io.kubernetes.cri.container-name")
+}
+
+// kubeletPod is what the kubelet reports about a pod and its containers.
+type kubeletPod struct {
+	Name      string
+	Namespace string
+	// Containers maps container IDs to their names in the pod spec.
+	Containers map[string]string
+	// Runtimes maps container IDs to the runtime running them.
+	Runtimes map[string]string
+}
+
+// kubeletResolver looks pods up in the /pods listing of the kubelet's read-only API. The
+// listing is fetched again when a pod or one of its containers is not known yet, at most
+// every refreshInterval, so an unreachable kubelet is not asked on every collection.
+type kubeletResolver struct {
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+
+	mu          sync.Mutex
+	pods        map[string]kubeletPod
+	lastAttempt time.Time
+}
+
+func newKubeletResolver(url string) *kubeletResolver {
+	return &kubeletResolver{
+		url:             strings.TrimSuffix(url, "/"),
+		client:          &http.Client{Timeout: 5 * time.Second},
+		refreshInterval: 10 * time.Second,
+		pods:            map[string]kubeletPod{},
+	}
+}
+
+// Pod returns the pod with the given UID. It reports false when the kubelet is disabled,
+// unreachable or does not know the pod. A known pod is fetched again when containerID is not
+// one of its containers yet, such as a container restarted since the last listing.
+func (r *kubeletResolver) Pod(uid, containerID string) (kubeletPod, bool, error) {
+	if r.url == "" {
+		return kubeletPod{}, false, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pod, ok := r.pods[uid]
+	if ok && (containerID == "" || pod.Containers[containerID] != "") {
+		return pod, true, nil
+	}
+	if time.Since(r.lastAttempt) < r.refreshInterval {
+		return pod, ok, nil
+	}
+	r.lastAttempt = time.Now()
+	pods, err := r.fetch()
+	if err != nil {
+		return pod, ok, err
+	}
+	r.pods = pods
+	pod, ok = r.pods[uid]
+	return pod, ok, nil
+}
+
+func (r *kubeletResolver) fetch() (map[string]kubeletPod, error) {
+	resp, err := r.client.Get(r.url + "/pods")
+	if err != nil {
+		return nil, fmt.Errorf("cannot list pods: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot list pods: %s", resp.Status)
+	}
+	type containerStatus struct {
+		Name        string `json:"name"`
+		ContainerID string `json:"containerID"`
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+				UID       string `json:"uid"`
+			} `json:"metadata"`
+			Status struct {
+				ContainerStatuses          []containerStatus `json:"containerStatuses"`
+				InitContainerStatuses      []containerStatus `json:"initContainerStatuses"`
+				EphemeralContainerStatuses []containerStatus `json:"ephemeralContainerStatuses"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("cannot decode pods: %w", err)
+	}
+	pods := make(map[string]kubeletPod, len(list.Items))
+	for _, item := range list.Items {
+		pod := kubeletPod{
+			Name:       item.Metadata.Name,
+			Namespace:  item.Metadata.Namespace,
+			Containers: map[string]string{},
+			Runtimes:   map[string]string{},
+		}
+		for _, statuses := range [][]containerStatus{item.Status.ContainerStatuses, item.Status.InitContainerStatuses, item.Status.EphemeralContainerStatuses} {
+			for _, status := range statuses {
+				// containerID is <runtime>://<id>, e.g. containerd://0123...
+				runtime, id, ok := strings.Cut(status.ContainerID, "://")
+				if !ok {
+					continue
+				}
+				pod.Containers[id] = status.Name
+				pod.Runtimes[id] = runtime
+			}
+		}
+		pods[item.Metadata.UID] = pod
+	}
+	return pods, nil
+}
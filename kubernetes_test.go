@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKubeletResolverRefetchesNewContainers(t *testing.T) {
+	var fetches atomic.Int32
+	var containerID atomic.Value
+	containerID.Store("aaaa")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		fmt.Fprintf(w, `{"items": [{"metadata": {"name": "web-0", "namespace": "shop", "uid": "pod-1"},
+			"status": {"containerStatuses": [{"name": "nginx", "containerID": "containerd://%s"}]}}]}`, containerID.Load())
+	}))
+	defer server.Close()
+	resolver := newKubeletResolver(server.URL)
+
+	pod, ok, err := resolver.Pod("pod-1", "aaaa")
+	if err != nil || !ok || pod.Name != "web-0" || pod.Containers["aaaa"] != "nginx" || pod.Runtimes["aaaa"] != "containerd" {
+		t.Fatalf("Pod() = %+v, %v, %v", pod, ok, err)
+	}
+	resolver.Pod("pod-1", "aaaa")
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches of a known container, want 1", n)
+	}
+
+	// The container restarted with a new ID: the cached pod is returned until the listing
+	// may be fetched again
+	containerID.Store("bbbb")
+	if pod, ok, _ := resolver.Pod("pod-1", "bbbb"); !ok || pod.Name != "web-0" || fetches.Load() != 1 {
+		t.Fatalf("within the refresh interval: %+v, %v, %d fetches", pod, ok, fetches.Load())
+	}
+	resolver.lastAttempt = time.Now().Add(-resolver.refreshInterval)
+	if pod, ok, _ := resolver.Pod("pod-1", "bbbb"); !ok || pod.Containers["bbbb"] != "nginx" || fetches.Load() != 2 {
+		t.Fatalf("after the refresh interval: %+v, %v, %d fetches", pod, ok, fetches.Load())
+	}
+
+	// Unknown pods are rate-limited the same way
+	if _, ok, _ := resolver.Pod("pod-2", ""); ok || fetches.Load() != 2 {
+		t.Fatalf("unknown pod: %v, %d fetches", ok, fetches.Load())
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	login1Service = "org.freedesktop.login1"
+	login1Path    = dbus.ObjectPath("/org/freedesktop/login1")
+	login1Manager = "org.freedesktop.login1.Manager"
+	login1Session = "org.freedesktop.login1.Session"
+	login1User    = "org.freedesktop.login1.User"
+)
+
+// LogindSession is a session as systemd-logind tracks it. Unlike utmp, logind also knows
+// the sessions without a terminal, such as sftp, VS Code remote and cron.
+type LogindSession struct {
+	ID   string
+	UID  uint32
+	User string
+	// Seat is empty for sessions that are not attached to a seat, such as remote ones.
+	Seat       string
+	TTY        string
+	Display    string
+	Remote     bool
+	RemoteHost string
+	RemoteUser string
+	// Service is the PAM service that opened the session, e.g. sshd or systemd-user.
+	Service string
+	// Class is user, greeter, lock-screen or background, Type tty, x11, wayland, mir or unspecified.
+	Class string
+	Type  string
+	// State is online, active or closing.
+	State string
+	// Leader is the PID of the process that registered the session.
+	Leader    uint32
+	Started   time.Time
+	IdleHint  bool
+	IdleSince time.Time
+}
+
+// LogindUser is a user logind knows about. State is offline, lingering, online, active or closing.
+type LogindUser struct {
+	UID    uint32
+	Name   string
+	State  string
+	Linger bool
+}
+
+// LogindState is what logind reported in one collection.
+type LogindState struct {
+	Sessions []LogindSession
+	Seats    []string
+	Users    []LogindUser
+}
+
+// logindClient asks systemd-logind over D-Bus. The connection is kept between collections
+// and opened again after an error.
+type logindClient struct {
+	mu      sync.Mutex
+	address string
+	conn    *dbus.Conn
+}
+
+// newLogindClient connects to the bus at address, the system bus when it is empty.
+func newLogindClient(address string) *logindClient {
+	return &logindClient{address: address}
+}
+
+func (c *logindClient) connect() (*dbus.Conn, error) {
+	if c.conn != nil && c.conn.Connected() {
+		return c.conn, nil
+	}
+	var conn *dbus.Conn
+	var err error
+	if c.address == "" {
+		conn, err = dbus.ConnectSystemBus()
+	} else {
+		conn, err = dbus.Connect(c.address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to D-Bus: %w", err)
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+func (c *logindClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// State lists the sessions, seats and users of logind.
+func (c *logindClient) State() (*LogindState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	state, err := readLogindState(conn)
+	if err != nil {
+		conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	return state, nil
+}
+
+func readLogindState(conn *dbus.Conn) (*LogindState, error) {
+	manager := conn.Object(login1Service, login1Path)
+	state := &LogindState{}
+
+	// a(susso): session ID, UID, user name, seat ID, object path
+	var sessions []struct {
+		ID   string
+		UID  uint32
+		User string
+		Seat string
+		Path dbus.ObjectPath
+	}
+	if err := manager.Call(login1Manager+".ListSessions", 0).Store(&sessions); err != nil {
+		return nil, fmt.Errorf("ListSessions failed: %w", err)
+	}
+	for _, s := range sessions {
+		session := LogindSession{ID: s.ID, UID: s.UID, User: s.User, Seat: s.Seat}
+		var props map[string]dbus.Variant
+		err := conn.Object(login1Service, s.Path).Call("org.freedesktop.DBus.Properties.GetAll", 0, login1Session).Store(&props)
+		if err != nil {
+			// The session may have closed since it was listed
+			continue
+		}
+		session.TTY = stringProperty(props, "TTY")
+		session.Display = stringProperty(props, "Display")
+		session.Remote, _ = props["Remote"].Value().(bool)
+		session.RemoteHost = stringProperty(props, "RemoteHost")
+		session.RemoteUser = stringProperty(props, "RemoteUser")
+		session.Service = stringProperty(props, "Service")
+		session.Class = stringProperty(props, "Class")
+		session.Type = stringProperty(props, "Type")
+		session.State = stringProperty(props, "State")
+		session.Leader, _ = props["Leader"].Value().(uint32)
+		session.IdleHint, _ = props["IdleHint"].Value().(bool)
+		session.Started = usecProperty(props, "Timestamp")
+		session.IdleSince = usecProperty(props, "IdleSinceHint")
+		state.Sessions = append(state.Sessions, session)
+	}
+	sort.Slice(state.Sessions, func(i, j int) bool { return state.Sessions[i].ID < state.Sessions[j].ID })
+
+	// a(so): seat ID, object path
+	var seats []struct {
+		ID   string
+		Path dbus.ObjectPath
+	}
+	if err := manager.Call(login1Manager+".ListSeats", 0).Store(&seats); err != nil {
+		return nil, fmt.Errorf("ListSeats failed: %w", err)
+	}
+	for _, seat := range seats {
+		state.Seats = append(state.Seats, seat.ID)
+	}
+	sort.Strings(state.Seats)
+
+	// a(uso): UID, user name, object path
+	var users []struct {
+		UID  uint32
+		Name string
+		Path dbus.ObjectPath
+	}
+	if err := manager.Call(login1Manager+".ListUsers", 0).Store(&users); err != nil {
+		return nil, fmt.Errorf("ListUsers failed: %w", err)
+	}
+	for _, u := range users {
+		user := LogindUser{UID: u.UID, Name: u.Name}
+		var props map[string]dbus.Variant
+		err := conn.Object(login1Service, u.Path).Call("org.freedesktop.DBus.Properties.GetAll", 0, login1User).Store(&props)
+		if err == nil {
+			user.State = stringProperty(props, "State")
+			user.Linger, _ = props["Linger"].Value().(bool)
+		}
+		state.Users = append(state.Users, user)
+	}
+	sort.Slice(state.Users, func(i, j int) bool { return state.Users[i].Name < state.Users[j].Name })
+	return state, nil
+}
+
+func stringProperty(props map[string]dbus.Variant, name string) string {
+	s, _ := props[name].Value().(string)
+	return s
+}
+
+// usecProperty converts a logind timestamp, microseconds since the epoch, with 0 meaning unset.
+func usecProperty(props map[string]dbus.Variant, name string) time.Time {
+	usec, _ := props[name].Value().(uint64)
+	if usec == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(int64(usec))
+}
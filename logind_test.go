@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*"/>
+    <allow receive_sender="*"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startBus runs a private dbus-daemon and returns its address.
+func startBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(busConfig, filepath.Join(dir, "bus.sock"))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--nopidfile", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("dbus-daemon did not print its address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+type fakeSessionEntry struct {
+	ID   string
+	UID  uint32
+	User string
+	Seat string
+	Path dbus.ObjectPath
+}
+
+type fakeSeatEntry struct {
+	ID   string
+	Path dbus.ObjectPath
+}
+
+type fakeUserEntry struct {
+	UID  uint32
+	Name string
+	Path dbus.ObjectPath
+}
+
+// fakeLogind is the part of the org.freedesktop.login1.Manager interface readLogindState uses.
+type fakeLogind struct {
+	sessions []fakeSessionEntry
+	seats    []fakeSeatEntry
+	users    []fakeUserEntry
+}
+
+func (m *fakeLogind) ListSessions() ([]fakeSessionEntry, *dbus.Error) { return m.sessions, nil }
+func (m *fakeLogind) ListSeats() ([]fakeSeatEntry, *dbus.Error)       { return m.seats, nil }
+func (m *fakeLogind) ListUsers() ([]fakeUserEntry, *dbus.Error)       { return m.users, nil }
+
+func exportProperties(t *testing.T, conn *dbus.Conn, path dbus.ObjectPath, iface string, values map[string]any) {
+	t.Helper()
+	props := map[string]*prop.Prop{}
+	for name, value := range values {
+		props[name] = &prop.Prop{Value: value, Emit: prop.EmitFalse}
+	}
+	if _, err := prop.Export(conn, path, prop.Map{iface: props}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReadLogindState serves a fake logind on a private bus. The lists are out of order and
+// session 9 is listed but gone by the time its properties are asked for, as when it closes
+// in between.
+func TestReadLogindState(t *testing.T) {
+	address := startBus(t)
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	manager := &fakeLogind{
+		sessions: []fakeSessionEntry{
+			{ID: "c1", UID: 1000, User: "alice", Path: "/org/freedesktop/login1/session/c1"},
+			{ID: "9", UID: 1001, User: "bob", Path: "/org/freedesktop/login1/session/_39"},
+			{ID: "2", UID: 1000, User: "alice", Seat: "seat0", Path: "/org/freedesktop/login1/session/_32"},
+		},
+		seats: []fakeSeatEntry{{ID: "seat1", Path: "/org/freedesktop/login1/seat/seat1"}, {ID: "seat0", Path: "/org/freedesktop/login1/seat/seat0"}},
+		users: []fakeUserEntry{{UID: 1001, Name: "bob", Path: "/org/freedesktop/login1/user/_1001"}, {UID: 1000, Name: "alice", Path: "/org/freedesktop/login1/user/_1000"}},
+	}
+	if err := conn.Export(manager, login1Path, login1Manager); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	idle := started.Add(time.Hour)
+	exportProperties(t, conn, "/org/freedesktop/login1/session/_32", login1Session, map[string]any{
+		"TTY": "tty1", "Display": "", "Remote": false, "RemoteHost": "", "RemoteUser": "", "Service": "login",
+		"Class": "user", "Type": "tty", "State": "active", "Leader": uint32(812), "IdleHint": true,
+		"Timestamp": uint64(started.UnixMicro()), "IdleSinceHint": uint64(idle.UnixMicro()),
+	})
+	exportProperties(t, conn, "/org/freedesktop/login1/session/c1", login1Session, map[string]any{
+		"TTY": "", "Display": "", "Remote": true, "RemoteHost": "203.0.113.7", "RemoteUser": "", "Service": "sshd",
+		"Class": "user", "Type": "unspecified", "State": "online", "Leader": uint32(4242), "IdleHint": false,
+		"Timestamp": uint64(started.UnixMicro()), "IdleSinceHint": uint64(0),
+	})
+	exportProperties(t, conn, "/org/freedesktop/login1/user/_1000", login1User, map[string]any{"State": "active", "Linger": true})
+	// bob has no properties: a user that cannot be asked is still listed
+	if reply, err := conn.RequestName(login1Service, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("cannot own %s: %v, %v", login1Service, reply, err)
+	}
+
+	client := newLogindClient(address)
+	defer client.Close()
+	state, err := client.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &LogindState{
+		Sessions: []LogindSession{
+			{ID: "2", UID: 1000, User: "alice", Seat: "seat0", TTY: "tty1", Service: "login", Class: "user", Type: "tty",
+				State: "active", Leader: 812, Started: time.UnixMicro(started.UnixMicro()), IdleHint: true, IdleSince: time.UnixMicro(idle.UnixMicro())},
+			{ID: "c1", UID: 1000, User: "alice", Remote: true, RemoteHost: "203.0.113.7", Service: "sshd", Class: "user",
+				Type: "unspecified", State: "online", Leader: 4242, Started: time.UnixMicro(started.UnixMicro())},
+		},
+		Seats: []string{"seat0", "seat1"},
+		Users: []LogindUser{{UID: 1000, Name: "alice", State: "active", Linger: true}, {UID: 1001, Name: "bob"}},
+	}
+	if !reflect.DeepEqual(state, want) {
+		t.Errorf("State() =\n%+v\nwant\n%+v", state, want)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// fileTail reads what was appended to a log file since the previous read. A rotated or
+// truncated file is read again from the start.
+type fileTail struct {
+	path   string
+	info   os.FileInfo
+	offset int64
+}
+
+// skipToEnd makes the next read start at the end of the file, aligned down to a whole
+// record of recordSize bytes, without reading what is already in it. A missing file is
+// read from the start once it appears.
+func (t *fileTail) skipToEnd(recordSize int64) error {
+	info, err := os.Stat(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	t.info = info
+	t.offset = info.Size() - info.Size()%recordSize
+	return nil
+}
+
+// read passes the new contents of the file to consume, which returns how many bytes it
+// used; the rest, such as a partly written record or line, is passed again next time.
+// A missing file has no new contents.
+func (t *fileTail) read(consume func(data []byte) int) error {
+	file, err := os.Open(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if t.info == nil || !os.SameFile(t.info, info) || info.Size() < t.offset {
+		t.offset = 0
+	}
+	t.info = info
+	if _, err := file.Seek(t.offset, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	t.offset += int64(consume(data))
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileTailSkipToEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "btmp")
+	tail := fileTail{path: path}
+	if err := tail.skipToEnd(4); err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+
+	// Two whole records and half of a third one that is still being written
+	if err := os.WriteFile(path, []byte("aaaabbbbcc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tail.skipToEnd(4); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("ccdddd")
+	file.Close()
+
+	var got string
+	if err := tail.read(func(data []byte) int {
+		got = string(data)
+		return len(data)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "ccccdddd" {
+		t.Errorf("read %q after skipping, want the records completed since: ccccdddd", got)
+	}
+}
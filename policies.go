@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+)
+
+// sessionKinds are the values classifySession returns, the kinds a rule can select.
+var sessionKinds = []string{sessionConsole, sessionTmux, sessionScreen, sessionSSH, sessionX11}
+
+// PolicyViolation is how many of the sessions of a user break a rule. Every user with a
+// session the rule applies to is listed, with Sessions 0 when the rule is kept.
+type PolicyViolation struct {
+	Rule     string
+	User     string
+	Sessions int
+}
+
+type sessionPolicy struct {
+	name        string
+	users       UserFilter
+	kinds       []string
+	maxSessions int
+	// restricted is set when the rule limits where remote sessions may come from.
+	restricted bool
+	networks   []netip.Prefix
+	sites      []string
+}
+
+// sessionPolicies is the compiled form of the rules of the configuration.
+type sessionPolicies struct {
+	rules []sessionPolicy
+}
+
+func newSessionPolicies(configs []RuleConfig, sites []SiteConfig) (*sessionPolicies, error) {
+	var errs []error
+	p := &sessionPolicies{}
+	names := map[string]bool{}
+	for i, config := range configs {
+		key := fmt.Sprintf("rules[%d]", i)
+		if config.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: must not be empty", key))
+		} else if names[config.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicate rule %q", key, config.Name))
+		}
+		names[config.Name] = true
+		restricted := len(config.AllowNetworks) > 0 || len(config.AllowSites) > 0
+		if config.MaxSessions == 0 && !restricted {
+			errs = append(errs, fmt.Errorf("%s: needs max_sessions, allow_networks or allow_sites", key))
+		}
+		if config.MaxSessions < 0 {
+			errs = append(errs, fmt.Errorf("%s.max_sessions: must not be negative, got %d", key, config.MaxSessions))
+		}
+		for _, kind := range config.Kinds {
+			if !slices.Contains(sessionKinds, kind) {
+				errs = append(errs, fmt.Errorf("%s.kinds: unknown kind %q, valid kinds are %v", key, kind, sessionKinds))
+			}
+		}
+		rule := sessionPolicy{name: config.Name, users: config.Users, kinds: config.Kinds, maxSessions: config.MaxSessions,
+			restricted: restricted, sites: config.AllowSites}
+		for _, network := range config.AllowNetworks {
+			prefix, err := netip.ParsePrefix(network)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.allow_networks: %w", key, err))
+				continue
+			}
+			rule.networks = append(rule.networks, prefix.Masked())
+		}
+		for _, name := range config.AllowSites {
+			if !slices.ContainsFunc(sites, func(s SiteConfig) bool { return s.Name == name }) {
+				errs = append(errs, fmt.Errorf("%s.allow_sites: unknown site %q, see sessions.sites", key, name))
+			}
+		}
+		p.rules = append(p.rules, rule)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *sessionPolicy) applies(s *Session) bool {
+	if !r.users.match(s.User) {
+		return false
+	}
+	return len(r.kinds) == 0 || slices.Contains(r.kinds, s.Kind)
+}
+
+// allowedFrom reports whether the session comes from where the rule allows. Local sessions
+// always do; a remote session whose address is a host name cannot be placed and does not.
+func (r *sessionPolicy) allowedFrom(s *Session) bool {
+	if !r.restricted || s.Address == "" {
+		return true
+	}
+	if s.Site != "" && slices.Contains(r.sites, s.Site) {
+		return true
+	}
+	addr, err := netip.ParseAddr(s.Address)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(r.networks, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+// check evaluates every rule against the current sessions, which must already be enriched
+// with their kind, address and site. A session that is both from outside and over the limit
+// is counted once: the sessions over the limit are taken from the outside ones first.
+func (p *sessionPolicies) check(sessions []Session) []PolicyViolation {
+	var violations []PolicyViolation
+	for i := range p.rules {
+		rule := &p.rules[i]
+		counts := map[string]int{}
+		outside := map[string]int{}
+		for j := range sessions {
+			s := &sessions[j]
+			if !rule.applies(s) {
+				continue
+			}
+			counts[s.User]++
+			if !rule.allowedFrom(s) {
+				outside[s.User]++
+			}
+		}
+		users := make([]string, 0, len(counts))
+		for user := range counts {
+			users = append(users, user)
+		}
+		sort.Strings(users)
+		for _, user := range users {
+			n := outside[user]
+			if rule.maxSessions > 0 {
+				n = max(n, counts[user]-rule.maxSessions)
+			}
+			violations = append(violations, PolicyViolation{Rule: rule.name, User: user, Sessions: n})
+		}
+	}
+	return violations
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSites = []SiteConfig{{Name: "office", Networks: []string{"192.0.2.0/24"}}}
+
+// sshSession returns a remote session of user from address, placed the way sessionEnricher does.
+func sshSession(user, address string) Session {
+	s := Session{User: user, Kind: sessionSSH, Address: address}
+	if strings.HasPrefix(address, "192.0.2.") {
+		s.Site = "office"
+	}
+	return s
+}
+
+func localSession(user, kind string) Session {
+	return Session{User: user, Kind: kind}
+}
+
+func TestSessionPoliciesCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     RuleConfig
+		sessions []Session
+		want     map[string]int
+	}{
+		{
+			name:     "max sessions",
+			rule:     RuleConfig{MaxSessions: 2},
+			sessions: []Session{localSession("alice", sessionConsole), localSession("alice", sessionTmux), localSession("alice", sessionScreen), localSession("bob", sessionConsole)},
+			want:     map[string]int{"alice": 1, "bob": 0},
+		},
+		{
+			name:     "allowed networks",
+			rule:     RuleConfig{AllowNetworks: []string{"10.8.0.0/16"}},
+			sessions: []Session{sshSession("alice", "10.8.1.2"), sshSession("alice", "203.0.113.7"), sshSession("bob", "10.8.3.4")},
+			want:     map[string]int{"alice": 1, "bob": 0},
+		},
+		{
+			name:     "allowed sites",
+			rule:     RuleConfig{AllowSites: []string{"office"}},
+			sessions: []Session{sshSession("alice", "192.0.2.10"), sshSession("alice", "203.0.113.7"), sshSession("alice", "2001:db8::7")},
+			want:     map[string]int{"alice": 2},
+		},
+		{
+			name:     "IPv4-mapped address",
+			rule:     RuleConfig{AllowNetworks: []string{"10.8.0.0/16"}},
+			sessions: []Session{sshSession("alice", "::ffff:10.8.1.2")},
+			want:     map[string]int{"alice": 0},
+		},
+		{
+			// The README example: the session over the limit is one of those from elsewhere
+			name: "limit and networks together",
+			rule: RuleConfig{MaxSessions: 3, AllowSites: []string{"office"}},
+			sessions: []Session{sshSession("alice", "192.0.2.10"), sshSession("alice", "192.0.2.11"),
+				sshSession("alice", "203.0.113.7"), sshSession("alice", "203.0.113.8")},
+			want: map[string]int{"alice": 2},
+		},
+		{
+			name: "over the limit with all sessions allowed",
+			rule: RuleConfig{MaxSessions: 1, AllowSites: []string{"office"}},
+			sessions: []Session{sshSession("alice", "192.0.2.10"), sshSession("alice", "192.0.2.11"),
+				localSession("alice", sessionConsole)},
+			want: map[string]int{"alice": 2},
+		},
+		{
+			name:     "kinds",
+			rule:     RuleConfig{Kinds: []string{sessionSSH}, MaxSessions: 1},
+			sessions: []Session{sshSession("alice", "10.8.1.2"), localSession("alice", sessionTmux), localSession("alice", sessionConsole), localSession("bob", sessionX11)},
+			want:     map[string]int{"alice": 0},
+		},
+		{
+			name:     "host name only",
+			rule:     RuleConfig{AllowNetworks: []string{"10.8.0.0/16"}},
+			sessions: []Session{sshSession("alice", "gw.example.com")},
+			want:     map[string]int{"alice": 1},
+		},
+		{
+			name:     "local sessions are always allowed",
+			rule:     RuleConfig{AllowNetworks: []string{"10.8.0.0/16"}},
+			sessions: []Session{localSession("alice", sessionConsole), localSession("alice", sessionTmux), {User: "alice", Kind: sessionX11}},
+			want:     map[string]int{"alice": 0},
+		},
+		{
+			name:     "users",
+			rule:     RuleConfig{Users: UserFilter{Exclude: []string{"root"}}, MaxSessions: 1},
+			sessions: []Session{localSession("root", sessionConsole), localSession("root", sessionTmux), localSession("alice", sessionConsole)},
+			want:     map[string]int{"alice": 0},
+		},
+		{
+			name: "no sessions",
+			rule: RuleConfig{MaxSessions: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = "test"
+			policies, err := newSessionPolicies([]RuleConfig{tt.rule}, testSites)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]int{}
+			for _, v := range policies.check(tt.sessions) {
+				if v.Rule != "test" {
+					t.Errorf("violation of rule %q", v.Rule)
+				}
+				got[v.User] = v.Sessions
+			}
+			if len(got)+len(tt.want) > 0 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSessionPoliciesErrors(t *testing.T) {
+	_, err := newSessionPolicies([]RuleConfig{
+		{Name: "a"},
+		{Name: "a", MaxSessions: -1},
+		{Name: "b", Kinds: []string{"rdp"}, AllowNetworks: []string{"10.8.0.0/33"}, AllowSites: []string{"home"}},
+	}, testSites)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{
+		"rules[0]: needs max_sessions",
+		`rules[1].name: duplicate rule "a"`,
+		"rules[1].max_sessions: must not be negative",
+		`rules[2].kinds: unknown kind "rdp"`,
+		"rules[2].allow_networks:",
+		`rules[2].allow_sites: unknown site "home"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const procRoot = "/proc"
+
+// noAuditSession is the /proc/<pid>/sessionid of processes outside an audited login session.
+const noAuditSession = 4294967295
+
+// pfKthread is the PF_KTHREAD bit of the flags field in /proc/<pid>/stat.
+const pfKthread = 0x00200000
+
+// clockTicks is USER_HZ, the unit of the time fields in /proc/<pid>/stat.
+// It is 100 on every architecture Linux currently supports.
+const clockTicks = 100
+
+// Process is a single process read from /proc/<pid>.
+type Process struct {
+	UID       int
+	User      string
+	PID       int
+	PPID      int
+	State     string
+	Comm      string
+	Flags     uint64 // PF_* flags
+	UTime     uint64 // clock ticks
+	STime     uint64 // clock ticks
+	VSZ       uint64 // bytes
+	RSS       uint64 // bytes
+	Threads   int
+	StartTime time.Time
+	Cmdline   []string
+	// Exe is the path of the executable, empty when /proc/<pid>/exe cannot be read.
+	Exe string
+	// FDs is only valid when HasFDs is set; another user's fd directory is only readable by root.
+	FDs    int
+	HasFDs bool
+	// AuditSessionID is the login session the kernel audit subsystem assigned the process to,
+	// kept across re-parenting. Only valid when HasAuditSession is set.
+	AuditSessionID  uint32
+	HasAuditSession bool
+	// CPUPercent is the CPU time divided by the time the process has been running,
+	// the same value ps reports as %CPU.
+	CPUPercent float64
+	// IO is only valid when HasIO is set; /proc/<pid>/io is not readable for every process.
+	IO    ProcessIO
+	HasIO bool
+}
+
+// Command returns the full command line, or the bracketed comm for kernel threads like ps does.
+func (p *Process) Command() string {
+	if len(p.Cmdline) == 0 {
+		return "[" + p.Comm + "]"
+	}
+	return strings.Join(p.Cmdline, " ")
+}
+
+// IsKernelThread reports whether the process is a kernel thread, by its PF_KTHREAD flag.
+func (p *Process) IsKernelThread() bool {
+	return p.Flags&pfKthread != 0
+}
+
+// CPUSeconds returns the total user and system CPU time consumed by the process.
+func (p *Process) CPUSeconds() float64 {
+	return float64(p.UTime+p.STime) / clockTicks
+}
+
+var userNameCache sync.Map
+
+func lookupUserName(uid int) string {
+	if name, ok := userNameCache.Load(uid); ok {
+		return name.(string)
+	}
+	name := strconv.Itoa(uid)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	userNameCache.Store(uid, name)
+	return name
+}
+
+// parseProcStat parses /proc/<pid>/stat. The comm field is enclosed in parentheses
+// and may itself contain spaces and parentheses, so the fields are split after the last ')'.
+func parseProcStat(data []byte, p *Process) error {
+	open := bytes.IndexByte(data, '(')
+	end := bytes.LastIndexByte(data, ')')
+	if open < 0 || end < open {
+		return fmt.Errorf("malformed stat line")
+	}
+	p.Comm = string(data[open+1 : end])
+	// fields[0] is field 3 (state) in proc(5)
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 22 {
+		return fmt.Errorf("stat line has %d fields, want at least 22", len(fields))
+	}
+	var err error
+	p.State = fields[0]
+	if p.PPID, err = strconv.Atoi(fields[1]); err != nil {
+		return fmt.Errorf("invalid ppid: %w", err)
+	}
+	if p.Flags, err = strconv.ParseUint(fields[6], 10, 64); err != nil {
+		return fmt.Errorf("invalid flags: %w", err)
+	}
+	if p.UTime, err = strconv.ParseUint(fields[11], 10, 64); err != nil {
+		return fmt.Errorf("invalid utime: %w", err)
+	}
+	if p.STime, err = strconv.ParseUint(fields[12], 10, 64); err != nil {
+		return fmt.Errorf("invalid stime: %w", err)
+	}
+	if p.Threads, err = strconv.Atoi(fields[17]); err != nil {
+		return fmt.Errorf("invalid num_threads: %w", err)
+	}
+	startTicks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid starttime: %w", err)
+	}
+	p.StartTime = bootTime.Add(time.Duration(startTicks) * time.Second / clockTicks)
+	return nil
+}
+
+// parseProcStatm parses /proc/<pid>/statm, whose first two fields are the
+// total program size and resident set size in pages.
+func parseProcStatm(data []byte, p *Process) error {
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return fmt.Errorf("statm has %d fields, want at least 2", len(fields))
+	}
+	size, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size: %w", err)
+	}
+	resident, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid resident: %w", err)
+	}
+	pageSize := uint64(os.Getpagesize())
+	p.VSZ = size * pageSize
+	p.RSS = resident * pageSize
+	return nil
+}
+
+// parseProcStatusUID returns the real UID from the Uid line of /proc/<pid>/status.
+func parseProcStatusUID(data []byte) (int, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Uid:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			break
+		}
+		return strconv.Atoi(fields[1])
+	}
+	return 0, fmt.Errorf("no Uid line in status")
+}
+
+func parseProcCmdline(data []byte) []string {
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(string(data), "\x00")
+}
+
+var bootTime = readBootTime()
+
+func readBootTime() time.Time {
+	data, err := os.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			sec, err := strconv.ParseInt(fields[1], 10, 64)
+			if err == nil {
+				return time.Unix(sec, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// readProcess reads a single process from /proc/<pid>.
+func readProcess(pid int) (Process, error) {
+	p := Process{PID: pid}
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return p, err
+	}
+	if err := parseProcStat(stat, &p); err != nil {
+		return p, fmt.Errorf("error parsing stat for PID %d: %w", pid, err)
+	}
+	statm, err := os.ReadFile(filepath.Join(dir, "statm"))
+	if err != nil {
+		return p, err
+	}
+	if err := parseProcStatm(statm, &p); err != nil {
+		return p, fmt.Errorf("error parsing statm for PID %d: %w", pid, err)
+	}
+	status, err := os.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return p, err
+	}
+	if p.UID, err = parseProcStatusUID(status); err != nil {
+		return p, fmt.Errorf("error parsing status for PID %d: %w", pid, err)
+	}
+	p.User = lookupUserName(p.UID)
+	cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return p, err
+	}
+	p.Cmdline = parseProcCmdline(cmdline)
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		p.Exe = strings.TrimSuffix(exe, " (deleted)")
+	}
+	if fds, err := countDirEntries(filepath.Join(dir, "fd")); err == nil {
+		p.FDs = fds
+		p.HasFDs = true
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "sessionid")); err == nil {
+		if id, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32); err == nil && id != noAuditSession {
+			p.AuditSessionID = uint32(id)
+			p.HasAuditSession = true
+		}
+	}
+	if pio, err := readProcessIO(pid); err == nil {
+		p.IO = pio
+		p.HasIO = true
+	}
+
+	if elapsed := time.Since(p.StartTime).Seconds(); elapsed > 0 {
+		p.CPUPercent = p.CPUSeconds() / elapsed * 100
+	}
+	return p, nil
+}
+
+func countDirEntries(dir string) (int, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	return len(names), err
+}
+
+// readProcesses scans /proc and returns every process sorted by RSS, largest first.
+// Processes that exit while being read are skipped.
+func readProcesses() ([]Process, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", procRoot, err)
+	}
+	processes := make([]Process, 0, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		p, err := readProcess(pid)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				slog.Debug("Cannot read process", "pid", pid, "error", err)
+			}
+			continue
+		}
+		processes = append(processes, p)
+	}
+	sort.Slice(processes, func(i, j int) bool { return processes[i].RSS > processes[j].RSS })
+	return processes, nil
+}
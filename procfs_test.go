@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readTestProcFile(t *testing.T, pid int, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata/proc", strconv.Itoa(pid), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The fixtures under testdata/proc/{2,700,800} are stat, statm, status and io files of
+// kthreadd, of a process whose comm holds spaces and parentheses, and truncated ones.
+func TestParseProcStat(t *testing.T) {
+	tests := []struct {
+		name    string
+		pid     int
+		want    Process
+		kthread bool
+	}{
+		{
+			name: "comm with spaces and parentheses",
+			pid:  700,
+			want: Process{Comm: "x) S 9 (y z", State: "S", PPID: 1, Flags: 4194560, UTime: 150, STime: 37, Threads: 2,
+				StartTime: bootTime.Add(123456 * time.Second / clockTicks)},
+		},
+		{
+			name: "kernel thread",
+			pid:  2,
+			want: Process{Comm: "kthreadd", State: "S", Flags: 2129984, STime: 3, Threads: 1,
+				StartTime: bootTime.Add(9 * time.Second / clockTicks)},
+			kthread: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Process
+			if err := parseProcStat(readTestProcFile(t, tt.pid, "stat"), &p); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, tt.want) {
+				t.Errorf("got %+v, want %+v", p, tt.want)
+			}
+			if p.IsKernelThread() != tt.kthread {
+				t.Errorf("IsKernelThread() = %v, want %v", p.IsKernelThread(), tt.kthread)
+			}
+		})
+	}
+}
+
+func TestParseProcStatErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"short", readTestProcFile(t, 800, "stat"), "stat line has 4 fields, want at least 22"},
+		{"no comm", []byte("800 short S 1 800 800\n"), "malformed stat line"},
+		{"parentheses reversed", []byte("800 )short( S 1 800 800\n"), "malformed stat line"},
+		{"invalid ppid", []byte(strings.Replace(string(readTestProcFile(t, 700, "stat")), "(y z) S 1 ", "(y z) S x ", 1)), "invalid ppid"},
+		{"invalid starttime", []byte(strings.Replace(string(readTestProcFile(t, 2, "stat")), " 9 ", " -9 ", 1)), "invalid starttime"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p Process
+			if err := parseProcStat(tt.data, &p); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProcStatm(t *testing.T) {
+	pageSize := uint64(os.Getpagesize())
+	var p Process
+	if err := parseProcStatm(readTestProcFile(t, 700, "statm"), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.VSZ != 3014*pageSize || p.RSS != 1024*pageSize {
+		t.Errorf("VSZ, RSS = %d, %d, want %d, %d", p.VSZ, p.RSS, 3014*pageSize, 1024*pageSize)
+	}
+	// Kernel threads have no memory of their own
+	if err := parseProcStatm(readTestProcFile(t, 2, "statm"), &p); err != nil || p.VSZ != 0 || p.RSS != 0 {
+		t.Errorf("kernel thread: VSZ, RSS = %d, %d, %v", p.VSZ, p.RSS, err)
+	}
+	if err := parseProcStatm(readTestProcFile(t, 800, "statm"), &p); err == nil {
+		t.Error("short statm: no error")
+	}
+	if err := parseProcStatm([]byte("3014 -1 512 100 0 800 0\n"), &p); err == nil || !strings.Contains(err.Error(), "invalid resident") {
+		t.Errorf("invalid resident: error %v", err)
+	}
+}
+
+func TestParseProcStatusUID(t *testing.T) {
+	// The real UID, not the effective, saved or file system one
+	if uid, err := parseProcStatusUID(readTestProcFile(t, 700, "status")); err != nil || uid != 1000 {
+		t.Errorf("uid = %d, %v, want 1000", uid, err)
+	}
+	if uid, err := parseProcStatusUID(readTestProcFile(t, 2, "status")); err != nil || uid != 0 {
+		t.Errorf("kernel thread: uid = %d, %v, want 0", uid, err)
+	}
+	if _, err := parseProcStatusUID(readTestProcFile(t, 800, "status")); err == nil {
+		t.Error("empty Uid line: no error")
+	}
+	if _, err := parseProcStatusUID([]byte("Name:\tx\nUid:\tx\t0\t0\t0\n")); err == nil {
+		t.Error("invalid Uid: no error")
+	}
+}
+
+func TestParseProcCmdline(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		{"/usr/bin/tmux\x00new-session\x00-s\x00a b\x00", []string{"/usr/bin/tmux", "new-session", "-s", "a b"}},
+		// Processes may rewrite their command line without the trailing NUL
+		{"sshd: alice [priv]", []string{"sshd: alice [priv]"}},
+		{"", nil},
+		{"\x00\x00", nil},
+	}
+	for _, tt := range tests {
+		if got := parseProcCmdline([]byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseProcCmdline(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
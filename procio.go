@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcessIO holds the I/O accounting counters from /proc/<pid>/io.
+type ProcessIO struct {
+	RChar               uint64
+	WChar               uint64
+	SyscR               uint64
+	SyscW               uint64
+	ReadBytes           uint64
+	WriteBytes          uint64
+	CancelledWriteBytes uint64
+}
+
+// parseProcIO parses the "key: value" lines of /proc/<pid>/io.
+func parseProcIO(data []byte) (ProcessIO, error) {
+	var pio ProcessIO
+	fields := map[string]*uint64{
+		"rchar":                 &pio.RChar,
+		"wchar":                 &pio.WChar,
+		"syscr":                 &pio.SyscR,
+		"syscw":                 &pio.SyscW,
+		"read_bytes":            &pio.ReadBytes,
+		"write_bytes":           &pio.WriteBytes,
+		"cancelled_write_bytes": &pio.CancelledWriteBytes,
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		field, ok := fields[key]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return pio, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*field = v
+	}
+	return pio, scanner.Err()
+}
+
+// readProcessIO reads /proc/<pid>/io. Reading another user's io file requires root
+// (or CAP_SYS_PTRACE), so callers should expect permission errors when unprivileged.
+func readProcessIO(pid int) (ProcessIO, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "io"))
+	if err != nil {
+		return ProcessIO{}, err
+	}
+	return parseProcIO(data)
+}
+
+// IORate is the read and write throughput of a process between two collections.
+type IORate struct {
+	ReadBytesPerSec  float64
+	WriteBytesPerSec float64
+}
+
+type ioSample struct {
+	start time.Time
+	at    time.Time
+	io    ProcessIO
+}
+
+// ioRateTracker remembers the previous I/O counters of every process so rates can be
+// computed between successive collections. A PID is matched together with its start
+// time so a reused PID is not mistaken for the previous process.
+type ioRateTracker struct {
+	mu   sync.Mutex
+	prev map[int]ioSample
+}
+
+func newIORateTracker() *ioRateTracker {
+	return &ioRateTracker{prev: map[int]ioSample{}}
+}
+
+// update records the current counters and returns the rate for each process that was
+// also present in the previous collection.
+func (t *ioRateTracker) update(processes []Process, now time.Time) map[int]IORate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rates := map[int]IORate{}
+	current := make(map[int]ioSample, len(processes))
+	for _, p := range processes {
+		if !p.HasIO {
+			continue
+		}
+		sample := ioSample{start: p.StartTime, at: now, io: p.IO}
+		current[p.PID] = sample
+		prev, ok := t.prev[p.PID]
+		if !ok || !prev.start.Equal(p.StartTime) {
+			continue
+		}
+		elapsed := now.Sub(prev.at).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		rates[p.PID] = IORate{
+			ReadBytesPerSec:  counterDelta(prev.io.ReadBytes, p.IO.ReadBytes) / elapsed,
+			WriteBytesPerSec: counterDelta(prev.io.WriteBytes, p.IO.WriteBytes) / elapsed,
+		}
+	}
+	t.prev = current
+	return rates
+}
+
+func counterDelta(prev, cur uint64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur - prev)
+}
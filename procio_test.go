@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProcIO(t *testing.T) {
+	tests := []struct {
+		name string
+		pid  int
+		want ProcessIO
+	}{
+		{
+			name: "all fields",
+			pid:  700,
+			want: ProcessIO{RChar: 323934931, WChar: 323929600, SyscR: 632687, SyscW: 632675,
+				ReadBytes: 4096, WriteBytes: 323932160, CancelledWriteBytes: 8192},
+		},
+		{
+			// A missing field is left at zero
+			name: "no read_bytes",
+			pid:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcIO(readTestProcFile(t, tt.pid, "io"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Unknown keys and lines without a colon are skipped
+	got, err := parseProcIO([]byte("rchar: 1\nfuture_field: x\ngarbage\nwchar: 2\n"))
+	if err != nil || got != (ProcessIO{RChar: 1, WChar: 2}) {
+		t.Errorf("unknown lines: got %+v, %v", got, err)
+	}
+	if _, err := parseProcIO(readTestProcFile(t, 800, "io")); err == nil || !strings.Contains(err.Error(), "invalid read_bytes") {
+		t.Errorf("invalid value: error %v", err)
+	}
+}
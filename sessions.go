@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// sessionDurationBuckets are the upper bounds, in seconds, of the session duration histogram:
+// a minute, 5 and 15 minutes, 1, 2, 4, 8 and 24 hours and a week.
+var sessionDurationBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400, 604800}
+
+// LoginCount counts the logins and logouts of one user by kind of session and site. The
+// remote host itself is left out: tmux and screen record PIDs in it and clients change
+// their addresses, so the counts would never stop growing.
+type LoginCount struct {
+	User string
+	// Kind is what classifySession returns, Site the site of the address, see sessionEnricher.
+	Kind    string
+	Site    string
+	Logins  uint64
+	Logouts uint64
+}
+
+// UserSessionHistory is the duration histogram of the ended sessions of one user and the
+// time of the user's last login.
+type UserSessionHistory struct {
+	User string
+	// Buckets counts the sessions no longer than each of sessionDurationBuckets, cumulatively.
+	Buckets         map[float64]uint64
+	Ended           uint64
+	DurationSeconds float64
+	LastLogin       time.Time
+}
+
+// SessionLifecycle is what the sessionTracker has seen since the exporter started.
+type SessionLifecycle struct {
+	Logins []LoginCount
+	Users  []UserSessionHistory
+}
+
+type loginSource struct {
+	user string
+	kind string
+	site string
+}
+
+type sessionKey struct {
+	tty   string
+	pid   int
+	login int64
+}
+
+func keyOf(s Session) sessionKey {
+	return sessionKey{tty: s.TTY, pid: s.PID, login: s.LoginTime.UnixNano()}
+}
+
+// sessionTracker counts logins and logouts. Without a wtmp file it compares the utmp
+// sessions of successive collections, which misses sessions shorter than the interval.
+// With one it reads the records appended to wtmp since the previous collection.
+type sessionTracker struct {
+	mu      sync.Mutex
+	options SessionsConfig
+	started bool
+
+	// open are the sessions seen logged in, by utmp key or, for wtmp, by terminal line.
+	open     map[sessionKey]Session
+	openWtmp map[string]Session
+	wtmp     fileTail
+
+	counts  map[loginSource]*LoginCount
+	history map[string]*UserSessionHistory
+}
+
+func newSessionTracker(options SessionsConfig) *sessionTracker {
+	return &sessionTracker{
+		options:  options,
+		open:     map[sessionKey]Session{},
+		openWtmp: map[string]Session{},
+		wtmp:     fileTail{path: options.Wtmp},
+		counts:   map[loginSource]*LoginCount{},
+		history:  map[string]*UserSessionHistory{},
+	}
+}
+
+// count returns the counts for the source of s, which must have been placed by sessionEnricher.
+func (t *sessionTracker) count(s Session) *LoginCount {
+	source := loginSource{user: s.User, kind: s.Kind, site: s.Site}
+	c, ok := t.counts[source]
+	if !ok {
+		c = &LoginCount{User: s.User, Kind: s.Kind, Site: s.Site}
+		t.counts[source] = c
+	}
+	return c
+}
+
+func (t *sessionTracker) userHistory(user string) *UserSessionHistory {
+	h, ok := t.history[user]
+	if !ok {
+		h = &UserSessionHistory{User: user, Buckets: map[float64]uint64{}}
+		t.history[user] = h
+	}
+	return h
+}
+
+func (t *sessionTracker) login(s Session) {
+	t.count(s).Logins++
+	t.seen(s)
+}
+
+// seen only records the login time, for sessions that were already open when tracking started.
+func (t *sessionTracker) seen(s Session) {
+	h := t.userHistory(s.User)
+	if s.LoginTime.After(h.LastLogin) {
+		h.LastLogin = s.LoginTime
+	}
+}
+
+func (t *sessionTracker) logout(s Session, at time.Time) {
+	t.count(s).Logouts++
+	h := t.userHistory(s.User)
+	duration := max(at.Sub(s.LoginTime).Seconds(), 0)
+	h.Ended++
+	h.DurationSeconds += duration
+	for _, bound := range sessionDurationBuckets {
+		if duration <= bound {
+			h.Buckets[bound]++
+		}
+	}
+}
+
+// update records the logins and logouts since the previous collection and returns the totals.
+// sessions are the currently logged in sessions from utmp, enriched by enricher, which also
+// places the wtmp records.
+func (t *sessionTracker) update(sessions []Session, now time.Time, enricher *sessionEnricher) SessionLifecycle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.options.Wtmp != "" {
+		var err error
+		if t.started || t.options.ReplayWtmp {
+			err = t.readWtmp(enricher)
+		} else {
+			err = t.skipWtmp(sessions)
+		}
+		if err != nil {
+			slog.Warn("Cannot read wtmp", "path", t.options.Wtmp, "error", err)
+		}
+		for _, s := range sessions {
+			t.seen(s)
+		}
+	} else {
+		current := make(map[sessionKey]Session, len(sessions))
+		for _, s := range sessions {
+			key := keyOf(s)
+			current[key] = s
+			if _, ok := t.open[key]; !ok && t.started {
+				t.login(s)
+			} else {
+				t.seen(s)
+			}
+		}
+		for key, s := range t.open {
+			if _, ok := current[key]; !ok {
+				t.logout(s, now)
+			}
+		}
+		t.open = current
+	}
+	t.started = true
+	return t.lifecycle()
+}
+
+// skipWtmp starts reading wtmp at its end, without the history already in it, which can be
+// large. The sessions open now are taken from utmp instead, so their logouts are counted.
+func (t *sessionTracker) skipWtmp(sessions []Session) error {
+	if err := t.wtmp.skipToEnd(int64(utmpRecordSize)); err != nil {
+		return fmt.Errorf("error reading %s: %w", t.options.Wtmp, err)
+	}
+	for _, s := range sessions {
+		t.openWtmp[s.TTY] = s
+	}
+	return nil
+}
+
+// readWtmp applies the records appended to wtmp since the previous call, on the first call
+// the whole history when ReplayWtmp is set. A rotated or truncated file is read again from
+// the start.
+func (t *sessionTracker) readWtmp(enricher *sessionEnricher) error {
+	var records []Session
+	var readErr error
+	err := t.wtmp.read(func(data []byte) int {
+		records, readErr = readUtmpRecords(bytes.NewReader(data))
+		return len(records) * utmpRecordSize
+	})
+	if err := errors.Join(err, readErr); err != nil {
+		return fmt.Errorf("error reading %s: %w", t.options.Wtmp, err)
+	}
+
+	for _, r := range records {
+		switch r.Type {
+		case utUserProcess:
+			if r.User == "" {
+				continue
+			}
+			enricher.place(&r)
+			t.openWtmp[r.TTY] = r
+			t.login(r)
+		case utDeadProcess:
+			s, ok := t.openWtmp[r.TTY]
+			if !ok {
+				continue
+			}
+			delete(t.openWtmp, r.TTY)
+			t.logout(s, r.LoginTime)
+		case utBootTime:
+			// Sessions still open at a reboot ended with the crash or shutdown before it
+			for tty, s := range t.openWtmp {
+				delete(t.openWtmp, tty)
+				t.logout(s, r.LoginTime)
+			}
+		}
+	}
+	return nil
+}
+
+// lifecycle copies the totals, so the snapshot does not change with later collections.
+func (t *sessionTracker) lifecycle() SessionLifecycle {
+	var l SessionLifecycle
+	for _, c := range t.counts {
+		l.Logins = append(l.Logins, *c)
+	}
+	sort.Slice(l.Logins, func(i, j int) bool {
+		if l.Logins[i].User != l.Logins[j].User {
+			return l.Logins[i].User < l.Logins[j].User
+		}
+		if l.Logins[i].Kind != l.Logins[j].Kind {
+			return l.Logins[i].Kind < l.Logins[j].Kind
+		}
+		return l.Logins[i].Site < l.Logins[j].Site
+	})
+	for _, h := range t.history {
+		copied := *h
+		copied.Buckets = maps.Clone(h.Buckets)
+		l.Users = append(l.Users, copied)
+	}
+	sort.Slice(l.Users, func(i, j int) bool { return l.Users[i].User < l.Users[j].User })
+	return l
+}
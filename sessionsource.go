@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session kinds, from the host field utmp records for each way of logging in.
+const (
+	sessionConsole = "console"
+	sessionTmux    = "tmux"
+	sessionScreen  = "screen"
+	sessionSSH     = "ssh"
+	sessionX11     = "x11"
+)
+
+// screenHostRE matches the host screen records for its windows, e.g. ":pts/3:S.0".
+var screenHostRE = regexp.MustCompile(`^:[^:]+:S\.\d+$`)
+
+// xDisplayRE matches an X display such as ":0" or "10.0.0.5:0.0" for XDMCP, capturing the host.
+var xDisplayRE = regexp.MustCompile(`^([^:]*):\d+(\.\d+)?$`)
+
+// classifySession returns how a session was started and, for remote sessions, the full
+// address it came from.
+func classifySession(s *Session) (kind, address string) {
+	host := s.Host
+	switch {
+	case host == "":
+		return sessionConsole, ""
+	case strings.HasPrefix(host, "tmux("):
+		return sessionTmux, ""
+	case screenHostRE.MatchString(host):
+		return sessionScreen, ""
+	case s.Addr != nil:
+		return sessionSSH, s.Addr.String()
+	case net.ParseIP(host) != nil:
+		return sessionSSH, host
+	}
+	if m := xDisplayRE.FindStringSubmatch(host); m != nil {
+		return sessionX11, m[1]
+	}
+	// mosh records "203.0.113.7 via mosh [1234]"
+	address, _, _ = strings.Cut(host, " ")
+	return sessionSSH, address
+}
+
+type site struct {
+	name   string
+	prefix netip.Prefix
+}
+
+// sessionEnricher fills in the kind, address, host name and site of sessions.
+type sessionEnricher struct {
+	// sites are sorted by prefix length, longest first, so the most specific network wins.
+	sites []site
+	dns   *reverseDNSCache
+}
+
+func newSessionEnricher(config SessionsConfig) (*sessionEnricher, error) {
+	var errs []error
+	e := &sessionEnricher{}
+	for i, s := range config.Sites {
+		key := fmt.Sprintf("sessions.sites[%d]", i)
+		if s.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: must not be empty", key))
+		}
+		for _, network := range s.Networks {
+			prefix, err := netip.ParsePrefix(network)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s.networks: %w", key, err))
+				continue
+			}
+			e.sites = append(e.sites, site{name: s.Name, prefix: prefix.Masked()})
+		}
+	}
+	if config.ReverseDNS && config.ReverseDNSTTL <= 0 {
+		errs = append(errs, fmt.Errorf("sessions.reverse_dns_ttl: must be positive, got %s", config.ReverseDNSTTL))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(e.sites, func(i, j int) bool { return e.sites[i].prefix.Bits() > e.sites[j].prefix.Bits() })
+	if config.ReverseDNS {
+		e.dns = newReverseDNSCache(config.ReverseDNSTTL)
+	}
+	return e, nil
+}
+
+// place fills in the kind, address and site of a session, but not its host name.
+func (e *sessionEnricher) place(s *Session) {
+	s.Kind, s.Address = classifySession(s)
+	if addr, err := netip.ParseAddr(s.Address); err == nil {
+		s.Site = e.site(addr.Unmap())
+	}
+}
+
+// site returns the name of the most specific site that contains addr, empty when none does.
+func (e *sessionEnricher) site(addr netip.Addr) string {
+	for _, site := range e.sites {
+		if site.prefix.Contains(addr) {
+			return site.name
+		}
+	}
+	return ""
+}
+
+func (e *sessionEnricher) enrich(sessions []Session) {
+	for i := range sessions {
+		s := &sessions[i]
+		e.place(s)
+		if s.Address == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(s.Address)
+		if err != nil {
+			// sshd with UseDNS records the host name instead of the address
+			s.RemoteHost = s.Address
+			continue
+		}
+		addr = addr.Unmap()
+		switch {
+		case s.Kind == sessionSSH && net.ParseIP(s.Host) == nil && !strings.Contains(s.Host, " "):
+			// sshd with UseDNS recorded the host name next to the address
+			s.RemoteHost = s.Host
+		case e.dns != nil:
+			s.RemoteHost = e.dns.lookup(addr)
+		}
+	}
+}
+
+type dnsEntry struct {
+	name    string
+	expires time.Time
+}
+
+// reverseDNSCache resolves addresses to host names. Failed lookups are cached too, so an
+// address without a PTR record is not asked for again on every collection. Lookups run in
+// the background so a slow resolver cannot hold up a collection.
+type reverseDNSCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[netip.Addr]dnsEntry
+	// pending are the addresses being resolved.
+	pending map[netip.Addr]bool
+}
+
+func newReverseDNSCache(ttl time.Duration) *reverseDNSCache {
+	return &reverseDNSCache{ttl: ttl, entries: map[netip.Addr]dnsEntry{}, pending: map[netip.Addr]bool{}}
+}
+
+// lookup returns the cached name of addr, empty until the first lookup completed. An
+// expired name is still returned while it is resolved again, so remote_host does not
+// flap on every refresh.
+func (c *reverseDNSCache) lookup(addr netip.Addr) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	entry, ok := c.entries[addr]
+	if ok && now.Before(entry.expires) {
+		return entry.name
+	}
+	for a, e := range c.entries {
+		// Forget the addresses nobody asked for during a whole TTL
+		if now.Sub(e.expires) > c.ttl {
+			delete(c.entries, a)
+		}
+	}
+	if !c.pending[addr] {
+		c.pending[addr] = true
+		go c.resolve(addr)
+	}
+	return entry.name
+}
+
+func (c *reverseDNSCache) resolve(addr netip.Addr) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var name string
+	names, err := net.DefaultResolver.LookupAddr(ctx, addr.String())
+	if err != nil {
+		slog.Debug("Cannot resolve session address", "address", addr, "error", err)
+	} else if len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[addr] = dnsEntry{name: name, expires: time.Now().Add(c.ttl)}
+	delete(c.pending, addr)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClassifySession(t *testing.T) {
+	tests := []struct {
+		host    string
+		addr    net.IP
+		kind    string
+		address string
+	}{
+		{"", nil, sessionConsole, ""},
+		{"tmux(4711).%0", nil, sessionTmux, ""},
+		{":pts/3:S.0", nil, sessionScreen, ""},
+		{"203.0.113.7", net.ParseIP("203.0.113.7"), sessionSSH, "203.0.113.7"},
+		// sshd with UseDNS records the host name, the address is still in the record
+		{"gw.example.com", net.ParseIP("2001:db8::7"), sessionSSH, "2001:db8::7"},
+		{"gw.example.com", nil, sessionSSH, "gw.example.com"},
+		{"2001:db8::7", nil, sessionSSH, "2001:db8::7"},
+		{"203.0.113.7 via mosh [1234]", nil, sessionSSH, "203.0.113.7"},
+		{":0", nil, sessionX11, ""},
+		{":1.0", nil, sessionX11, ""},
+		{"10.0.0.5:0.0", nil, sessionX11, "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		kind, address := classifySession(&Session{Host: tt.host, Addr: tt.addr})
+		if kind != tt.kind || address != tt.address {
+			t.Errorf("classifySession(%q, %s) = %s, %q, want %s, %q", tt.host, tt.addr, kind, address, tt.kind, tt.address)
+		}
+	}
+}
+
+func TestSessionEnricherSites(t *testing.T) {
+	enricher, err := newSessionEnricher(SessionsConfig{Sites: []SiteConfig{
+		{Name: "campus", Networks: []string{"10.0.0.0/8", "2001:db8::/32"}},
+		{Name: "vpn", Networks: []string{"10.8.0.0/16"}},
+		{Name: "admins", Networks: []string{"10.8.1.0/24", "2001:db8:1::/48"}},
+		// The host bits are ignored
+		{Name: "gateway", Networks: []string{"10.8.1.1/32", "192.0.2.77/24"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		addr net.IP
+		site string
+	}{
+		{"10.1.2.3", net.ParseIP("10.1.2.3"), "campus"},
+		{"10.8.3.4", net.ParseIP("10.8.3.4"), "vpn"},
+		{"10.8.1.2", net.ParseIP("10.8.1.2"), "admins"},
+		{"10.8.1.1", net.ParseIP("10.8.1.1"), "gateway"},
+		{"192.0.2.10", net.ParseIP("192.0.2.10"), "gateway"},
+		{"2001:db8:2::7", net.ParseIP("2001:db8:2::7"), "campus"},
+		{"2001:db8:1::7", net.ParseIP("2001:db8:1::7"), "admins"},
+		{"::ffff:10.8.1.2", nil, "admins"},
+		{"10.8.1.2:0", nil, "admins"},
+		{"203.0.113.7", net.ParseIP("203.0.113.7"), ""},
+		{"gw.example.com", nil, ""},
+		{"", nil, ""},
+	}
+	sessions := make([]Session, len(tests))
+	for i, tt := range tests {
+		sessions[i] = Session{Host: tt.host, Addr: tt.addr}
+	}
+	enricher.enrich(sessions)
+	for i, tt := range tests {
+		if sessions[i].Site != tt.site {
+			t.Errorf("site of %q = %q, want %q", tt.host, sessions[i].Site, tt.site)
+		}
+	}
+	if s := sessions[10]; s.RemoteHost != "gw.example.com" {
+		t.Errorf("RemoteHost of a host name = %q", s.RemoteHost)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+// SessionUsage is the combined usage of the processes started from one login session.
+// CPUSeconds, IOReadBytes and IOWriteBytes keep what exited processes used, so they only go up.
+type SessionUsage struct {
+	Processes  int
+	CPUSeconds float64
+	RSS        uint64
+	// IOReadBytes and IOWriteBytes only include the processes whose I/O counters could be read.
+	IOReadBytes  uint64
+	IOWriteBytes uint64
+}
+
+// maxTreeDepth bounds the walk up the process tree, in case PIDs are reused while /proc is read.
+const maxTreeDepth = 256
+
+// attributeSessions sums the usage of the processes of every session. A process belongs to
+// the session whose leader is its nearest ancestor. Processes that left the tree, such as
+// nohup jobs re-parented to init, are found by the audit session ID they share with the
+// session leader. The CPU and I/O counters continue from counters, using the growth of
+// every process in deltas.
+func attributeSessions(sessions []Session, processes []Process, deltas map[int]UsageDelta, counters *usageCounterSet[sessionKey]) {
+	byPID := make(map[int]*Process, len(processes))
+	for i := range processes {
+		byPID[processes[i].PID] = &processes[i]
+	}
+	leaders := map[int]int{}
+	audit := map[uint32]int{}
+	counters.begin()
+	for i := range sessions {
+		counters.touch(keyOf(sessions[i]))
+		sessions[i].Usage = SessionUsage{}
+		sessions[i].HasUsage = true
+		leaders[sessions[i].PID] = i
+		if leader, ok := byPID[sessions[i].PID]; ok && leader.HasAuditSession {
+			if _, ok := audit[leader.AuditSessionID]; !ok {
+				audit[leader.AuditSessionID] = i
+			}
+		}
+	}
+
+	for i := range processes {
+		p := &processes[i]
+		session, ok := -1, false
+		for pid, depth := p.PID, 0; pid > 1 && depth < maxTreeDepth; depth++ {
+			if session, ok = leaders[pid]; ok {
+				break
+			}
+			parent, found := byPID[pid]
+			if !found {
+				break
+			}
+			pid = parent.PPID
+		}
+		if !ok && p.HasAuditSession {
+			session, ok = audit[p.AuditSessionID]
+		}
+		if ok {
+			usage := &sessions[session].Usage
+			usage.Processes++
+			usage.RSS += p.RSS
+			counters.add(keyOf(sessions[session]), p, deltas[p.PID])
+		}
+	}
+
+	for i := range sessions {
+		total := counters.get(keyOf(sessions[i]))
+		sessions[i].Usage.CPUSeconds = total.CPUSeconds
+		sessions[i].Usage.IOReadBytes = total.IO.ReadBytes
+		sessions[i].Usage.IOWriteBytes = total.IO.WriteBytes
+	}
+	counters.end()
+}
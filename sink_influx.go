@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	ihttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// influxOptions are the connection and buffering settings of the InfluxDB sink.
+type influxOptions struct {
+	URL    string
+	Token  string
+	Org    string
+	Bucket string
+	// BatchSize is the maximum number of points per write request.
+	BatchSize int
+	// FlushInterval is how long points may wait for a batch to fill up.
+	FlushInterval time.Duration
+	// BufferSize caps the number of points held in memory while InfluxDB is slow or unreachable.
+	BufferSize int
+	// MaxRetries is how often a failed batch is retried before it is dropped.
+	MaxRetries int
+	// RetryInterval is the first retry delay, doubled after every failed attempt up to MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// CloseTimeout bounds how long Close writes the buffered points, including a write in progress.
+	CloseTimeout time.Duration
+	// PerProcess and PerUser enable the per-process and per-user measurements.
+	PerProcess bool
+	PerUser    bool
+}
+
+func (o *influxOptions) setDefaults() {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1000
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = time.Second
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = 100000
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Second
+	}
+	if o.MaxRetryInterval <= 0 {
+		o.MaxRetryInterval = 30 * time.Second
+	}
+	if o.CloseTimeout <= 0 {
+		o.CloseTimeout = 10 * time.Second
+	}
+}
+
+// validate reports every missing setting at once so the user does not have to fix them one by one.
+func (o influxOptions) validate() error {
+	var missing []string
+	for _, setting := range []struct{ name, value string }{
+		{"url", o.URL}, {"token", o.Token}, {"org", o.Org}, {"bucket", o.Bucket},
+	} {
+		if setting.value == "" {
+			missing = append(missing, setting.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("InfluxDB output requires %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// influxSink queues the points of every snapshot in a bounded in-memory buffer and writes
+// them to an InfluxDB v2 bucket in batches from a background goroutine, so a slow or
+// unreachable server never blocks or stops the collection loop.
+type influxSink struct {
+	options  influxOptions
+	client   influxdb2.Client
+	writeAPI api.WriteAPIBlocking
+	queue    chan *write.Point
+	done     chan struct{}
+	stopped  chan struct{}
+	// ctx is canceled CloseTimeout after Close was called, which aborts the writes still running.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	pointsWritten prometheus.Counter
+	pointsDropped prometheus.Counter
+	pointsRetried prometheus.Counter
+	bufferedDesc  *prometheus.Desc
+}
+
+func newInfluxSink(options influxOptions) *influxSink {
+	options.setDefaults()
+	client := influxdb2.NewClient(options.URL, options.Token)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &influxSink{
+		ctx:      ctx,
+		cancel:   cancel,
+		options:  options,
+		client:   client,
+		writeAPI: client.WriteAPIBlocking(options.Org, options.Bucket),
+		queue:    make(chan *write.Point, options.BufferSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		pointsWritten: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_influxdb_points_written_total",
+			Help: "Points successfully written to InfluxDB.",
+		}),
+		pointsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_influxdb_points_dropped_total",
+			Help: "Points discarded because the buffer was full, the retries were exhausted or InfluxDB rejected them.",
+		}),
+		pointsRetried: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "exporter_influxdb_points_retried_total",
+			Help: "Points in batches that were sent again after a failed write.",
+		}),
+		bufferedDesc: prometheus.NewDesc("exporter_influxdb_buffered_points",
+			"Points waiting in the buffer to be written to InfluxDB.", nil, nil),
+	}
+	go s.run()
+	return s
+}
+
+func (s *influxSink) Name() string {
+	return "influxdb"
+}
+
+// Write queues the points of the snapshot. When the buffer is full the oldest points are
+// dropped, so the newest data is written once InfluxDB is reachable again.
+func (s *influxSink) Write(snapshot *Snapshot) error {
+	dropped := 0
+	for _, point := range influxPoints(snapshot, s.options) {
+		select {
+		case s.queue <- point:
+			continue
+		default:
+		}
+		// Make room by discarding the oldest point; the writer may have taken one in the meantime
+		select {
+		case <-s.queue:
+			dropped++
+		default:
+		}
+		select {
+		case s.queue <- point:
+		default:
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		s.pointsDropped.Add(float64(dropped))
+		return fmt.Errorf("InfluxDB buffer is full, dropped %d points", dropped)
+	}
+	return nil
+}
+
+// Close stops the background writer after writing the points that are still buffered, or
+// dropping them when InfluxDB does not take them within CloseTimeout.
+func (s *influxSink) Close() {
+	close(s.done)
+	timer := time.AfterFunc(s.options.CloseTimeout, s.cancel)
+	<-s.stopped
+	timer.Stop()
+	s.cancel()
+	s.client.Close()
+}
+
+func (s *influxSink) closing() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Describe and Collect expose the sink's own counters, so they can be registered with the Prometheus sink.
+func (s *influxSink) Describe(ch chan<- *prometheus.Desc) {
+	s.pointsWritten.Describe(ch)
+	s.pointsDropped.Describe(ch)
+	s.pointsRetried.Describe(ch)
+	ch <- s.bufferedDesc
+}
+
+func (s *influxSink) Collect(ch chan<- prometheus.Metric) {
+	s.pointsWritten.Collect(ch)
+	s.pointsDropped.Collect(ch)
+	s.pointsRetried.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(s.bufferedDesc, prometheus.GaugeValue, float64(len(s.queue)))
+}
+
+// run collects points into batches and writes a batch when it is full or the flush interval has passed.
+func (s *influxSink) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.options.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*write.Point, 0, s.options.BatchSize)
+	for {
+		select {
+		case point := <-s.queue:
+			batch = append(batch, point)
+			if len(batch) >= s.options.BatchSize {
+				s.writeBatch(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.writeBatch(batch)
+				batch = batch[:0]
+			}
+		case <-s.done:
+			s.flush(batch)
+			return
+		}
+	}
+}
+
+// flush writes the partial batch and the buffered points in batches of BatchSize. Once
+// the context is canceled the remaining points are dropped.
+func (s *influxSink) flush(batch []*write.Point) {
+	for {
+		for len(batch) < s.options.BatchSize && len(s.queue) > 0 {
+			batch = append(batch, <-s.queue)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if s.ctx.Err() != nil {
+			dropped := len(batch) + len(s.queue)
+			slog.Error("Cannot write the buffered points to InfluxDB before closing, dropping them", "points", dropped)
+			s.pointsDropped.Add(float64(dropped))
+			return
+		}
+		s.writeBatch(batch)
+		batch = batch[:0]
+	}
+}
+
+// writeBatch writes one batch, retrying with exponential backoff on network errors, 429 and 5xx responses.
+// Once Close was called a failed batch is not retried.
+func (s *influxSink) writeBatch(batch []*write.Point) {
+	delay := s.options.RetryInterval
+	for attempt := 0; ; attempt++ {
+		err := s.writeAPI.WritePoint(s.ctx, batch...)
+		if err == nil {
+			s.pointsWritten.Add(float64(len(batch)))
+			return
+		}
+		var httpErr *ihttp.Error
+		retryable := !errors.As(err, &httpErr) || httpErr.StatusCode == 0 || httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500
+		if !retryable || attempt >= s.options.MaxRetries || s.closing() {
+			slog.Error("Cannot write points to InfluxDB, dropping batch", "points", len(batch), "attempts", attempt+1, "error", err)
+			s.pointsDropped.Add(float64(len(batch)))
+			return
+		}
+		wait := delay
+		if httpErr != nil && httpErr.RetryAfter > 0 {
+			wait = time.Duration(httpErr.RetryAfter) * time.Second
+		}
+		slog.Warn("Cannot write points to InfluxDB, retrying", "points", len(batch), "attempt", attempt+1, "retry_in", wait, "error", err)
+		s.pointsRetried.Add(float64(len(batch)))
+		select {
+		case <-time.After(wait):
+		case <-s.done:
+			// Shutting down, give the batch one last try without waiting
+		}
+		delay = min(delay*2, s.options.MaxRetryInterval)
+	}
+}
+
+// addPodTags tags the points of processes running in a Kubernetes pod.
+func addPodTags(tags map[string]string, pod *kubernetesPod) {
+	if pod == nil {
+		return
+	}
+	tags["pod"] = pod.Name
+	tags["namespace"] = pod.Namespace
+}
+
+func influxPoints(snapshot *Snapshot, options influxOptions) []*write.Point {
+	host_name := snapshot.Hostname
+	os_dist := snapshot.OS
+	os_version := snapshot.OSVersion
+	now := snapshot.Time
+	var points []*write.Point
+
+	tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version}
+	fields := map[string]interface{}{"number_of_users": len(snapshot.Sessions)}
+	points = append(points, write.NewPoint("logged_in_users", tags, fields, now))
+	for _, session := range snapshot.Sessions {
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version,
+			"user": session.User, "tty": session.TTY, "from": session.Host, "when": session.LoginTime.Format(time.RFC3339),
+			"address": session.Address, "kind": session.Kind, "remote_host": session.RemoteHost, "site": session.Site}
+		fields := map[string]interface{}{"logged_in": 1}
+		if session.HasIdle {
+			fields["idle_seconds"] = session.Idle.Seconds()
+		}
+		if session.HasUsage {
+			fields["processes"] = session.Usage.Processes
+			fields["cpu_seconds"] = session.Usage.CPUSeconds
+			fields["rss"] = session.Usage.RSS
+			fields["io_read_bytes"] = session.Usage.IOReadBytes
+			fields["io_write_bytes"] = session.Usage.IOWriteBytes
+		}
+		points = append(points, write.NewPoint("logged_in_user", tags, fields, now))
+	}
+	processes := snapshot.Processes
+	if !options.PerProcess {
+		processes = nil
+	}
+	for _, process := range processes {
+		if !process.HasIO {
+			continue
+		}
+		// read and write are KB/s since the previous collection, the remaining fields are cumulative counters
+		rate := process.IORate
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version,
+			"process_id": strconv.Itoa(process.PID), "username": process.User, "command": process.Command(),
+			"container_name": process.ContainerName, "container_id": process.ContainerID}
+		addPodTags(tags, process.Pod)
+		fields := map[string]interface{}{"read": rate.ReadBytesPerSec / 1024, "write": rate.WriteBytesPerSec / 1024,
+			"read_bytes": process.IO.ReadBytes, "write_bytes": process.IO.WriteBytes, "rchar": process.IO.RChar, "wchar": process.IO.WChar,
+			"syscr": process.IO.SyscR, "syscw": process.IO.SyscW, "cancelled_write_bytes": process.IO.CancelledWriteBytes}
+		points = append(points, write.NewPoint("process_read_write_in_KB", tags, fields, now))
+	}
+	for _, process := range processes {
+		process_command_str := process.Command()
+		// vsz and rss are reported in KiB like ps does
+		vsz_float := float64(process.VSZ / 1024)
+		rss_float := float64(process.RSS / 1024)
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version,
+			"username": process.User, "process_id": strconv.Itoa(process.PID), "command": process_command_str,
+			"container_name": process.ContainerName, "container_id": process.ContainerID}
+		addPodTags(tags, process.Pod)
+		fields := map[string]interface{}{"cpu_percent": process.CPUPercent, "vsz": vsz_float, "rss": rss_float}
+		points = append(points, write.NewPoint("process_mem_cpu", tags, fields, now))
+	}
+	for _, usage := range snapshot.Users {
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version, "user": usage.User}
+		// The session counts are written whether or not PerUser is set
+		fields := map[string]interface{}{"sessions": usage.Sessions, "stale_sessions": usage.StaleSessions}
+		if options.PerUser {
+			fields["processes"] = usage.Processes
+			fields["cpu_seconds"] = usage.CPUSeconds
+			fields["rss"] = usage.RSS
+			fields["io_read_bytes"] = usage.IOReadBytes
+			fields["io_write_bytes"] = usage.IOWriteBytes
+		}
+		points = append(points, write.NewPoint("user_usage", tags, fields, now))
+	}
+	for _, c := range snapshot.Lifecycle.Logins {
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version, "user": c.User, "kind": c.Kind, "site": c.Site}
+		fields := map[string]interface{}{"logins": c.Logins, "logouts": c.Logouts}
+		points = append(points, write.NewPoint("session_logins", tags, fields, now))
+	}
+	for _, h := range snapshot.Lifecycle.Users {
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version, "user": h.User}
+		fields := map[string]interface{}{"ended": h.Ended, "duration_seconds": h.DurationSeconds}
+		if !h.LastLogin.IsZero() {
+			fields["last_login"] = h.LastLogin.Unix()
+		}
+		points = append(points, write.NewPoint("session_history", tags, fields, now))
+	}
+	for _, f := range snapshot.FailedLogins {
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version, "user": f.User, "from": f.From, "method": f.Method}
+		fields := map[string]interface{}{"count": f.Count}
+		points = append(points, write.NewPoint("failed_logins", tags, fields, now))
+	}
+	if snapshot.Logind != nil {
+		for _, s := range snapshot.Logind.Sessions {
+			tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version, "session": s.ID, "user": s.User,
+				"seat": s.Seat, "tty": s.TTY, "remote_host": s.RemoteHost, "service": s.Service, "class": s.Class, "type": s.Type, "state": s.State}
+			fields := map[string]interface{}{"uid": s.UID, "leader": s.Leader, "remote": s.Remote, "idle": s.IdleHint}
+			if !s.Started.IsZero() {
+				fields["started"] = s.Started.Unix()
+			}
+			points = append(points, write.NewPoint("logind_session", tags, fields, now))
+		}
+		for _, u := range snapshot.Logind.Users {
+			tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version, "user": u.Name, "state": u.State}
+			fields := map[string]interface{}{"uid": u.UID, "linger": u.Linger}
+			points = append(points, write.NewPoint("logind_user", tags, fields, now))
+		}
+	}
+	for _, v := range snapshot.Violations {
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version, "rule": v.Rule, "user": v.User}
+		fields := map[string]interface{}{"sessions": v.Sessions}
+		points = append(points, write.NewPoint("policy_violations", tags, fields, now))
+	}
+	for _, usage := range snapshot.Groups {
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version, "group": usage.Group}
+		fields := map[string]interface{}{"processes": usage.Processes, "threads": usage.Threads, "cpu_seconds": usage.CPUSeconds,
+			"rss": usage.RSS, "vsz": usage.VSZ, "io_read_bytes": usage.IOReadBytes, "io_write_bytes": usage.IOWriteBytes, "open_fds": usage.OpenFDs}
+		points = append(points, write.NewPoint("group_usage", tags, fields, now))
+	}
+	for _, cg := range snapshot.Cgroups {
+		tags := map[string]string{"hostname": host_name, "os": os_dist, "os_version": os_version,
+			"cgroup": cg.Path, "unit": cg.Unit, "slice": cg.Slice, "user": cg.User}
+		fields := map[string]interface{}{}
+		if cg.HasCPU {
+			fields["cpu_seconds"] = cg.CPUSeconds
+		}
+		if cg.HasMemory {
+			fields["memory_bytes"] = cg.MemoryBytes
+		}
+		if cg.HasMemoryPeak {
+			fields["memory_peak_bytes"] = cg.MemoryPeakBytes
+		}
+		if cg.HasIO {
+			fields["io_read_bytes"] = cg.IOReadBytes
+			fields["io_write_bytes"] = cg.IOWriteBytes
+		}
+		if cg.HasPIDs {
+			fields["pids"] = cg.PIDs
+		}
+		for resource, psi := range cg.Pressure {
+			fields[resource+"_pressure_some_seconds"] = psi.SomeSeconds
+			if psi.HasFull {
+				fields[resource+"_pressure_full_seconds"] = psi.FullSeconds
+			}
+		}
+		// A point needs at least one field
+		if len(fields) > 0 {
+			points = append(points, write.NewPoint("cgroup_usage", tags, fields, now))
+		}
+	}
+	return points
+}
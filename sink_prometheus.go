@@ -0,0 +1,459 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	snapshotAgeDesc = prometheus.NewDesc("exporter_snapshot_age_seconds",
+		"Seconds since the served snapshot was collected.", []string{"hostname"}, nil)
+	collectionDurationDesc = prometheus.NewDesc("exporter_collection_duration_seconds",
+		"Seconds it took to collect the served snapshot.", []string{"hostname"}, nil)
+
+	loggedInUsersDesc = prometheus.NewDesc("logged_in_users",
+		"Number of currently logged-in user sessions.", []string{"hostname"}, nil)
+	// from is the host field of utmp; address, kind, remote_host and site are derived from it by sessionEnricher.
+	sessionLabels    = []string{"hostname", "user", "tty", "from", "when", "address", "kind", "remote_host", "site"}
+	loggedInUserDesc = prometheus.NewDesc("logged_in_user",
+		"A currently logged-in user session, always 1.", sessionLabels, nil)
+	loggedInUserIdleDesc = prometheus.NewDesc("logged_in_user_idle_seconds",
+		"Seconds since the terminal of the session was last read from, like the IDLE column of w.", sessionLabels, nil)
+	// The per-session usage covers the processes started from the session, see attributeSessions.
+	loggedInUserProcessesDesc  = prometheus.NewDesc("logged_in_user_processes", "Number of processes started from the session.", sessionLabels, nil)
+	loggedInUserCPUSecondsDesc = prometheus.NewDesc("logged_in_user_cpu_seconds_total", "User and system CPU time consumed by the processes of the session, including those that exited.", sessionLabels, nil)
+	loggedInUserRSSDesc        = prometheus.NewDesc("logged_in_user_resident_memory_bytes", "Resident set size of the processes of the session.", sessionLabels, nil)
+	loggedInUserIOReadDesc     = prometheus.NewDesc("logged_in_user_io_read_bytes_total", "Bytes the processes of the session caused to be fetched from the storage layer, including those that exited.", sessionLabels, nil)
+	loggedInUserIOWriteDesc    = prometheus.NewDesc("logged_in_user_io_write_bytes_total", "Bytes the processes of the session caused to be sent to the storage layer, including those that exited.", sessionLabels, nil)
+
+	userLabels         = []string{"hostname", "user"}
+	userProcessesDesc  = prometheus.NewDesc("user_processes", "Number of processes of the user.", userLabels, nil)
+	userCPUSecondsDesc = prometheus.NewDesc("user_cpu_seconds_total", "User and system CPU time consumed by the processes of the user, including those that exited.", userLabels, nil)
+	userRSSDesc        = prometheus.NewDesc("user_resident_memory_bytes", "Resident set size of the processes of the user.", userLabels, nil)
+	userIOReadDesc     = prometheus.NewDesc("user_io_read_bytes_total", "Bytes the processes of the user caused to be fetched from the storage layer, including those that exited.", userLabels, nil)
+	userIOWriteDesc    = prometheus.NewDesc("user_io_write_bytes_total", "Bytes the processes of the user caused to be sent to the storage layer, including those that exited.", userLabels, nil)
+	userSessionsDesc   = prometheus.NewDesc("user_sessions", "Number of logged-in sessions of the user.", userLabels, nil)
+	userDescs          = []*prometheus.Desc{userProcessesDesc, userCPUSecondsDesc, userRSSDesc, userIOReadDesc, userIOWriteDesc, userSessionsDesc}
+	// userStaleDesc goes with the session series and is emitted whether or not PerUser is set.
+	userStaleDesc = prometheus.NewDesc("user_stale_sessions", "Number of sessions of the user idle for longer than sessions.stale_after.", userLabels, nil)
+)
+
+// Metric schemas selectable with --metrics-schema.
+const (
+	// schemaCurrent only carries identity labels; every measured value is a sample value.
+	schemaCurrent = "current"
+	// schemaLegacy emits the original process_* names that carry values such as cpu_percent as labels.
+	schemaLegacy = "legacy"
+	// schemaBoth emits both for a transition period.
+	schemaBoth = "both"
+)
+
+// processLabelNames are the identity labels that can be selected for per-process series.
+var processLabelNames = []string{"pid", "user", "command", "comm", "container", "container_id", "container_image", "container_runtime", "pod", "namespace", "pod_uid", "qos_class"}
+
+// pod and namespace are empty, and so omitted by Prometheus, for processes outside Kubernetes.
+var defaultProcessLabels = []string{"pid", "user", "command", "container", "pod", "namespace"}
+
+func processLabelValue(p *ProcessSample, name string) string {
+	switch name {
+	case "pid":
+		return strconv.Itoa(p.PID)
+	case "user":
+		return p.User
+	case "command":
+		return p.Command()
+	case "comm":
+		return p.Comm
+	case "container":
+		return p.ContainerName
+	case "container_id":
+		return p.ContainerID
+	case "container_image":
+		return p.ContainerImage
+	case "container_runtime":
+		return p.ContainerRuntime
+	}
+	if p.Pod == nil {
+		return ""
+	}
+	switch name {
+	case "pod":
+		return p.Pod.Name
+	case "namespace":
+		return p.Pod.Namespace
+	case "pod_uid":
+		return p.Pod.UID
+	case "qos_class":
+		return p.Pod.QoS
+	}
+	return ""
+}
+
+func validateProcessLabels(labels []string) error {
+	for i, name := range labels {
+		if !slices.Contains(processLabelNames, name) {
+			return fmt.Errorf("unknown process label %q, valid labels are %s", name, strings.Join(processLabelNames, ", "))
+		}
+		if slices.Contains(labels[:i], name) {
+			return fmt.Errorf("duplicate process label %q", name)
+		}
+	}
+	return nil
+}
+
+func validateMetricsSchema(schema string) error {
+	switch schema {
+	case schemaCurrent, schemaLegacy, schemaBoth:
+		return nil
+	}
+	return fmt.Errorf("unknown metrics schema %q, valid schemas are %s, %s and %s", schema, schemaCurrent, schemaLegacy, schemaBoth)
+}
+
+// prometheusOptions controls which series the Prometheus sink exposes.
+type prometheusOptions struct {
+	// ProcessLabels are the identity labels of the per-process series. Processes that
+	// share the same label values, e.g. when pid is not selected, are summed into one series.
+	ProcessLabels []string
+	Schema        string
+	// PerProcess and PerUser enable the process_* and user_* series.
+	PerProcess bool
+	PerUser    bool
+}
+
+type processDescs struct {
+	count            *prometheus.Desc
+	cpuSeconds       *prometheus.Desc
+	virtualMemory    *prometheus.Desc
+	residentMemory   *prometheus.Desc
+	ioReadBytes      *prometheus.Desc
+	ioWriteBytes     *prometheus.Desc
+	ioReadChars      *prometheus.Desc
+	ioWriteChars     *prometheus.Desc
+	ioReadSyscalls   *prometheus.Desc
+	ioWriteSyscalls  *prometheus.Desc
+	ioCancelledWrite *prometheus.Desc
+}
+
+func newProcessDescs(processLabels []string) processDescs {
+	labels := append([]string{"hostname"}, processLabels...)
+	return processDescs{
+		count:            prometheus.NewDesc("host_process_count", "Number of processes sharing the label values, 1 when pid is a label.", labels, nil),
+		cpuSeconds:       prometheus.NewDesc("host_process_cpu_seconds_total", "User and system CPU time consumed by the process.", labels, nil),
+		virtualMemory:    prometheus.NewDesc("host_process_virtual_memory_bytes", "Virtual memory size of the process.", labels, nil),
+		residentMemory:   prometheus.NewDesc("host_process_resident_memory_bytes", "Resident set size of the process.", labels, nil),
+		ioReadBytes:      prometheus.NewDesc("host_process_io_read_bytes_total", "Bytes the process caused to be fetched from the storage layer.", labels, nil),
+		ioWriteBytes:     prometheus.NewDesc("host_process_io_write_bytes_total", "Bytes the process caused to be sent to the storage layer.", labels, nil),
+		ioReadChars:      prometheus.NewDesc("host_process_io_read_chars_total", "Bytes the process read via read-like system calls.", labels, nil),
+		ioWriteChars:     prometheus.NewDesc("host_process_io_write_chars_total", "Bytes the process wrote via write-like system calls.", labels, nil),
+		ioReadSyscalls:   prometheus.NewDesc("host_process_io_read_syscalls_total", "Read-like system calls made by the process.", labels, nil),
+		ioWriteSyscalls:  prometheus.NewDesc("host_process_io_write_syscalls_total", "Write-like system calls made by the process.", labels, nil),
+		ioCancelledWrite: prometheus.NewDesc("host_process_io_cancelled_write_bytes_total", "Bytes the process did not write because it truncated page cache.", labels, nil),
+	}
+}
+
+func (d processDescs) all() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		d.count, d.cpuSeconds, d.virtualMemory, d.residentMemory,
+		d.ioReadBytes, d.ioWriteBytes, d.ioReadChars, d.ioWriteChars,
+		d.ioReadSyscalls, d.ioWriteSyscalls, d.ioCancelledWrite,
+	}
+}
+
+// processSeries accumulates the values of every process that maps to the same label values.
+// The CPU and I/O counters keep what exited processes used, see usageCounterSet.
+type processSeries struct {
+	labels   []string
+	count    int
+	vsz      uint64
+	rss      uint64
+	counters *UsageCounters
+}
+
+// prometheusSink keeps the most recent snapshot and exposes it through a Prometheus registry on scrape.
+// It implements prometheus.Collector, so label escaping and the exposition format (text, OpenMetrics
+// or protobuf, depending on the Accept header) are handled by client_golang.
+type prometheusSink struct {
+	mu       sync.RWMutex
+	snapshot *Snapshot
+	options  prometheusOptions
+	process  processDescs
+	// series are the per-process series of the snapshot, built once in Write; counters keeps
+	// their CPU and I/O totals between snapshots.
+	series   []*processSeries
+	counters *usageCounterSet[string]
+	// extra are the collectors added with Register, kept to fill a replacement registry.
+	extra    []prometheus.Collector
+	registry *prometheus.Registry
+	handler  http.Handler
+}
+
+func newPrometheusSink(options prometheusOptions) *prometheusSink {
+	s := &prometheusSink{
+		options:  options,
+		process:  newProcessDescs(options.ProcessLabels),
+		counters: newUsageCounterSet[string](false),
+	}
+	s.registry, s.handler = s.newRegistry(nil)
+	return s
+}
+
+func (s *prometheusSink) newRegistry(extra []prometheus.Collector) (*prometheus.Registry, http.Handler) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		s,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	registry.MustRegister(extra...)
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+		ErrorHandling:     promhttp.ContinueOnError,
+	})
+	return registry, handler
+}
+
+func (s *prometheusSink) Name() string {
+	return "prometheus"
+}
+
+func (s *prometheusSink) Write(snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = snapshot
+	s.series = nil
+	if s.options.emitCurrent() {
+		s.series = aggregateProcesses(snapshot, s.options.ProcessLabels, s.counters)
+	}
+	return nil
+}
+
+// Register adds collectors of other components, such as the InfluxDB sink's counters, to the served registry.
+func (s *prometheusSink) Register(cs ...prometheus.Collector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registry.MustRegister(cs...)
+	s.extra = append(s.extra, cs...)
+}
+
+func (s *prometheusSink) Unregister(c prometheus.Collector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registry.Unregister(c)
+	s.extra = slices.DeleteFunc(s.extra, func(e prometheus.Collector) bool { return e == c })
+}
+
+// SetOptions replaces the options, e.g. after the configuration was reloaded. The registry
+// remembers the label names of every metric it has seen, so a new registry is built instead
+// of registering the sink again with different process labels.
+func (s *prometheusSink) SetOptions(options prometheusOptions) {
+	s.mu.Lock()
+	if !slices.Equal(options.ProcessLabels, s.options.ProcessLabels) {
+		// The series are keyed by their label values, which no longer match
+		s.counters = newUsageCounterSet[string](false)
+		s.series = nil
+	}
+	s.options = options
+	s.process = newProcessDescs(options.ProcessLabels)
+	extra := slices.Clone(s.extra)
+	s.mu.Unlock()
+	// Registering calls Describe, which takes the read lock
+	registry, handler := s.newRegistry(extra)
+	s.mu.Lock()
+	s.registry, s.handler = registry, handler
+	s.mu.Unlock()
+}
+
+func (s *prometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	handler := s.handler
+	s.mu.RUnlock()
+	handler.ServeHTTP(w, r)
+}
+
+func (o prometheusOptions) emitCurrent() bool {
+	return o.PerProcess && o.Schema != schemaLegacy
+}
+
+func (o prometheusOptions) emitLegacy() bool {
+	return o.PerProcess && (o.Schema == schemaLegacy || o.Schema == schemaBoth)
+}
+
+func (s *prometheusSink) Describe(ch chan<- *prometheus.Desc) {
+	s.mu.RLock()
+	options := s.options
+	process := s.process
+	s.mu.RUnlock()
+	for _, desc := range []*prometheus.Desc{snapshotAgeDesc, collectionDurationDesc, loggedInUsersDesc, loggedInUserDesc, loggedInUserIdleDesc,
+		loggedInUserProcessesDesc, loggedInUserCPUSecondsDesc, loggedInUserRSSDesc, loggedInUserIOReadDesc, loggedInUserIOWriteDesc} {
+		ch <- desc
+	}
+	for _, desc := range sessionDescs {
+		ch <- desc
+	}
+	if options.emitCurrent() {
+		for _, desc := range process.all() {
+			ch <- desc
+		}
+	}
+	if options.emitLegacy() {
+		for _, desc := range legacyProcessDescs {
+			ch <- desc
+		}
+	}
+	if options.PerUser {
+		for _, desc := range userDescs {
+			ch <- desc
+		}
+	}
+	ch <- userStaleDesc
+	for _, desc := range cgroupDescs {
+		ch <- desc
+	}
+	for _, desc := range groupDescs {
+		ch <- desc
+	}
+	for _, desc := range logindDescs {
+		ch <- desc
+	}
+}
+
+func (s *prometheusSink) Collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	snapshot := s.snapshot
+	options := s.options
+	process := s.process
+	series := s.series
+	s.mu.RUnlock()
+	if snapshot == nil {
+		return
+	}
+	host_name := snapshot.Hostname
+
+	sendMetric(ch, snapshotAgeDesc, prometheus.GaugeValue, time.Since(snapshot.Time).Seconds(), host_name)
+	sendMetric(ch, collectionDurationDesc, prometheus.GaugeValue, snapshot.Duration.Seconds(), host_name)
+
+	sendMetric(ch, loggedInUsersDesc, prometheus.GaugeValue, float64(len(snapshot.Sessions)), host_name)
+	for _, session := range snapshot.Sessions {
+		labels := []string{host_name, session.User, session.TTY, session.Host, session.LoginTime.Format(time.RFC3339),
+			session.Address, session.Kind, session.RemoteHost, session.Site}
+		sendMetric(ch, loggedInUserDesc, prometheus.GaugeValue, 1, labels...)
+		if session.HasIdle {
+			sendMetric(ch, loggedInUserIdleDesc, prometheus.GaugeValue, session.Idle.Seconds(), labels...)
+		}
+		if session.HasUsage {
+			sendMetric(ch, loggedInUserProcessesDesc, prometheus.GaugeValue, float64(session.Usage.Processes), labels...)
+			sendMetric(ch, loggedInUserCPUSecondsDesc, prometheus.CounterValue, session.Usage.CPUSeconds, labels...)
+			sendMetric(ch, loggedInUserRSSDesc, prometheus.GaugeValue, float64(session.Usage.RSS), labels...)
+			sendMetric(ch, loggedInUserIOReadDesc, prometheus.CounterValue, float64(session.Usage.IOReadBytes), labels...)
+			sendMetric(ch, loggedInUserIOWriteDesc, prometheus.CounterValue, float64(session.Usage.IOWriteBytes), labels...)
+		}
+	}
+	collectSessionLifecycle(ch, snapshot)
+	collectStaleSessions(ch, snapshot)
+	collectPolicies(ch, snapshot)
+	if options.emitCurrent() {
+		collectProcesses(ch, series, process)
+	}
+	if options.emitLegacy() {
+		collectLegacyProcesses(ch, snapshot)
+	}
+	if options.PerUser {
+		collectUsers(ch, snapshot)
+	}
+	collectCgroups(ch, snapshot)
+	collectGroups(ch, snapshot)
+	collectLogind(ch, snapshot)
+}
+
+// collectUsers emits the usage summed by user.
+func collectUsers(ch chan<- prometheus.Metric, snapshot *Snapshot) {
+	for _, usage := range snapshot.Users {
+		labels := []string{snapshot.Hostname, usage.User}
+		sendMetric(ch, userProcessesDesc, prometheus.GaugeValue, float64(usage.Processes), labels...)
+		sendMetric(ch, userCPUSecondsDesc, prometheus.CounterValue, usage.CPUSeconds, labels...)
+		sendMetric(ch, userRSSDesc, prometheus.GaugeValue, float64(usage.RSS), labels...)
+		sendMetric(ch, userIOReadDesc, prometheus.CounterValue, float64(usage.IOReadBytes), labels...)
+		sendMetric(ch, userIOWriteDesc, prometheus.CounterValue, float64(usage.IOWriteBytes), labels...)
+		sendMetric(ch, userSessionsDesc, prometheus.GaugeValue, float64(usage.Sessions), labels...)
+	}
+}
+
+// collectStaleSessions emits the number of stale sessions of every user.
+func collectStaleSessions(ch chan<- prometheus.Metric, snapshot *Snapshot) {
+	for _, usage := range snapshot.Users {
+		sendMetric(ch, userStaleDesc, prometheus.GaugeValue, float64(usage.StaleSessions), snapshot.Hostname, usage.User)
+	}
+}
+
+// aggregateProcesses sums the processes of the snapshot by their label values.
+func aggregateProcesses(snapshot *Snapshot, processLabels []string, counters *usageCounterSet[string]) []*processSeries {
+	var order []*processSeries
+	series := map[string]*processSeries{}
+	counters.begin()
+	for i := range snapshot.Processes {
+		process := &snapshot.Processes[i]
+		labels := make([]string, 0, len(processLabels)+1)
+		labels = append(labels, snapshot.Hostname)
+		for _, name := range processLabels {
+			labels = append(labels, processLabelValue(process, name))
+		}
+		key := strings.Join(labels, "\xff")
+		ps, ok := series[key]
+		if !ok {
+			ps = &processSeries{labels: labels}
+			series[key] = ps
+			order = append(order, ps)
+		}
+		ps.count++
+		ps.vsz += process.VSZ
+		ps.rss += process.RSS
+		ps.counters = counters.add(key, &process.Process, process.Delta)
+	}
+	counters.end()
+	return order
+}
+
+func collectProcesses(ch chan<- prometheus.Metric, series []*processSeries, d processDescs) {
+	for _, ps := range series {
+		labels := slices.Clone(ps.labels)
+		sendMetric(ch, d.count, prometheus.GaugeValue, float64(ps.count), labels...)
+		sendMetric(ch, d.cpuSeconds, prometheus.CounterValue, ps.counters.CPUSeconds, labels...)
+		sendMetric(ch, d.virtualMemory, prometheus.GaugeValue, float64(ps.vsz), labels...)
+		sendMetric(ch, d.residentMemory, prometheus.GaugeValue, float64(ps.rss), labels...)
+		if !ps.counters.HasIO {
+			continue
+		}
+		io := ps.counters.IO
+		sendMetric(ch, d.ioReadBytes, prometheus.CounterValue, float64(io.ReadBytes), labels...)
+		sendMetric(ch, d.ioWriteBytes, prometheus.CounterValue, float64(io.WriteBytes), labels...)
+		sendMetric(ch, d.ioReadChars, prometheus.CounterValue, float64(io.RChar), labels...)
+		sendMetric(ch, d.ioWriteChars, prometheus.CounterValue, float64(io.WChar), labels...)
+		sendMetric(ch, d.ioReadSyscalls, prometheus.CounterValue, float64(io.SyscR), labels...)
+		sendMetric(ch, d.ioWriteSyscalls, prometheus.CounterValue, float64(io.SyscW), labels...)
+		sendMetric(ch, d.ioCancelledWrite, prometheus.CounterValue, float64(io.CancelledWriteBytes), labels...)
+	}
+}
+
+// sendMetric emits a constant metric. Label values are forced to valid UTF-8 first because
+// command lines are arbitrary bytes; a metric that still fails validation is reported as
+// invalid instead of panicking.
+func sendMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labelValues ...string) {
+	for i, v := range labelValues {
+		labelValues[i] = strings.ToValidUTF8(v, "�")
+	}
+	metric, err := prometheus.NewConstMetric(desc, valueType, value, labelValues...)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(desc, err)
+		return
+	}
+	ch <- metric
+}
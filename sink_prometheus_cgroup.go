@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The per-cgroup series carry the cgroup path together with the systemd unit, slice and
+// user derived from it, so usage can be summed by service or by user slice.
+var (
+	cgroupLabels         = []string{"hostname", "cgroup", "unit", "slice", "user"}
+	cgroupPressureLabels = append(slices.Clone(cgroupLabels), "resource", "kind")
+	cgroupCPUSecondsDesc = prometheus.NewDesc("cgroup_cpu_seconds_total", "CPU time consumed by the tasks of the cgroup and its descendants.", cgroupLabels, nil)
+	cgroupMemoryDesc     = prometheus.NewDesc("cgroup_memory_bytes", "Memory currently charged to the cgroup and its descendants.", cgroupLabels, nil)
+	cgroupMemoryPeakDesc = prometheus.NewDesc("cgroup_memory_peak_bytes", "Highest memory usage recorded for the cgroup.", cgroupLabels, nil)
+	cgroupIOReadDesc     = prometheus.NewDesc("cgroup_io_read_bytes_total", "Bytes read from block devices by the cgroup.", cgroupLabels, nil)
+	cgroupIOWriteDesc    = prometheus.NewDesc("cgroup_io_write_bytes_total", "Bytes written to block devices by the cgroup.", cgroupLabels, nil)
+	cgroupPIDsDesc       = prometheus.NewDesc("cgroup_pids", "Number of tasks in the cgroup and its descendants.", cgroupLabels, nil)
+	cgroupPressureDesc   = prometheus.NewDesc("cgroup_pressure_seconds_total", "Time some or all tasks of the cgroup were stalled waiting for the resource (PSI, cgroup v2 only).", cgroupPressureLabels, nil)
+	cgroupDescs          = []*prometheus.Desc{cgroupCPUSecondsDesc, cgroupMemoryDesc, cgroupMemoryPeakDesc, cgroupIOReadDesc, cgroupIOWriteDesc, cgroupPIDsDesc, cgroupPressureDesc}
+)
+
+func collectCgroups(ch chan<- prometheus.Metric, snapshot *Snapshot) {
+	for _, cg := range snapshot.Cgroups {
+		labels := []string{snapshot.Hostname, cg.Path, cg.Unit, cg.Slice, cg.User}
+		if cg.HasCPU {
+			sendMetric(ch, cgroupCPUSecondsDesc, prometheus.CounterValue, cg.CPUSeconds, labels...)
+		}
+		if cg.HasMemory {
+			sendMetric(ch, cgroupMemoryDesc, prometheus.GaugeValue, float64(cg.MemoryBytes), labels...)
+		}
+		if cg.HasMemoryPeak {
+			sendMetric(ch, cgroupMemoryPeakDesc, prometheus.GaugeValue, float64(cg.MemoryPeakBytes), labels...)
+		}
+		if cg.HasIO {
+			sendMetric(ch, cgroupIOReadDesc, prometheus.CounterValue, float64(cg.IOReadBytes), labels...)
+			sendMetric(ch, cgroupIOWriteDesc, prometheus.CounterValue, float64(cg.IOWriteBytes), labels...)
+		}
+		if cg.HasPIDs {
+			sendMetric(ch, cgroupPIDsDesc, prometheus.GaugeValue, float64(cg.PIDs), labels...)
+		}
+		for _, resource := range []string{"cpu", "memory", "io"} {
+			psi, ok := cg.Pressure[resource]
+			if !ok {
+				continue
+			}
+			sendMetric(ch, cgroupPressureDesc, prometheus.CounterValue, psi.SomeSeconds, append(slices.Clone(labels), resource, "some")...)
+			if psi.HasFull {
+				sendMetric(ch, cgroupPressureDesc, prometheus.CounterValue, psi.FullSeconds, append(slices.Clone(labels), resource, "full")...)
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The per-group series sum the processes matched by the group rules, so short-lived processes
+// such as compilers do not create a series per PID.
+var (
+	groupLabels         = []string{"hostname", "group"}
+	groupProcessesDesc  = prometheus.NewDesc("group_processes", "Number of processes in the group.", groupLabels, nil)
+	groupThreadsDesc    = prometheus.NewDesc("group_threads", "Number of threads of the processes in the group.", groupLabels, nil)
+	groupCPUSecondsDesc = prometheus.NewDesc("group_cpu_seconds_total", "User and system CPU time consumed by the processes of the group, including those that exited.", groupLabels, nil)
+	groupRSSDesc        = prometheus.NewDesc("group_resident_memory_bytes", "Resident set size of the processes in the group.", groupLabels, nil)
+	groupVSZDesc        = prometheus.NewDesc("group_virtual_memory_bytes", "Virtual memory size of the processes in the group.", groupLabels, nil)
+	groupIOReadDesc     = prometheus.NewDesc("group_io_read_bytes_total", "Bytes the processes of the group caused to be fetched from the storage layer, including those that exited.", groupLabels, nil)
+	groupIOWriteDesc    = prometheus.NewDesc("group_io_write_bytes_total", "Bytes the processes of the group caused to be sent to the storage layer, including those that exited.", groupLabels, nil)
+	groupOpenFDsDesc    = prometheus.NewDesc("group_open_fds", "Number of file descriptors the processes in the group have open.", groupLabels, nil)
+	groupDescs          = []*prometheus.Desc{groupProcessesDesc, groupThreadsDesc, groupCPUSecondsDesc, groupRSSDesc, groupVSZDesc, groupIOReadDesc, groupIOWriteDesc, groupOpenFDsDesc}
+)
+
+func collectGroups(ch chan<- prometheus.Metric, snapshot *Snapshot) {
+	for _, usage := range snapshot.Groups {
+		labels := []string{snapshot.Hostname, usage.Group}
+		sendMetric(ch, groupProcessesDesc, prometheus.GaugeValue, float64(usage.Processes), labels...)
+		sendMetric(ch, groupThreadsDesc, prometheus.GaugeValue, float64(usage.Threads), labels...)
+		sendMetric(ch, groupCPUSecondsDesc, prometheus.CounterValue, usage.CPUSeconds, labels...)
+		sendMetric(ch, groupRSSDesc, prometheus.GaugeValue, float64(usage.RSS), labels...)
+		sendMetric(ch, groupVSZDesc, prometheus.GaugeValue, float64(usage.VSZ), labels...)
+		sendMetric(ch, groupIOReadDesc, prometheus.CounterValue, float64(usage.IOReadBytes), labels...)
+		sendMetric(ch, groupIOWriteDesc, prometheus.CounterValue, float64(usage.IOWriteBytes), labels...)
+		sendMetric(ch, groupOpenFDsDesc, prometheus.GaugeValue, float64(usage.OpenFDs), labels...)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The legacy process_* series carry measured values such as cpu_percent, vsz or read as labels,
+// so every change in value starts a new time series. They are only emitted with
+// --metrics-schema legacy or both, to give dashboards and alerts time to move to the current names.
+var (
+	legacyIORateLabels   = []string{"hostname", "process_id", "username", "read", "write", "container_name", "container_id", "command"}
+	legacyReadKBDesc     = prometheus.NewDesc("process_read_in_KB", "Deprecated: use rate(host_process_io_read_bytes_total). Disk read throughput of the process in KB/s.", legacyIORateLabels, nil)
+	legacyWriteKBDesc    = prometheus.NewDesc("process_write_in_KB", "Deprecated: use rate(host_process_io_write_bytes_total). Disk write throughput of the process in KB/s.", legacyIORateLabels, nil)
+	legacyMemCPULabels   = []string{"hostname", "username", "process_id", "cpu_percent", "vsz", "rss", "container_name", "container_id", "command"}
+	legacyCPUPercentDesc = prometheus.NewDesc("process_cpu_percent", "Deprecated: use rate(host_process_cpu_seconds_total). CPU time of the process divided by its run time, in percent.", legacyMemCPULabels, nil)
+	legacyVSZDesc        = prometheus.NewDesc("process_vsz", "Deprecated: use host_process_virtual_memory_bytes. Virtual memory size of the process in KiB.", legacyMemCPULabels, nil)
+	legacyRSSDesc        = prometheus.NewDesc("process_rss", "Deprecated: use host_process_resident_memory_bytes. Resident set size of the process in KiB.", legacyMemCPULabels, nil)
+	legacyProcessDescs   = []*prometheus.Desc{legacyReadKBDesc, legacyWriteKBDesc, legacyCPUPercentDesc, legacyVSZDesc, legacyRSSDesc}
+)
+
+func collectLegacyProcesses(ch chan<- prometheus.Metric, snapshot *Snapshot) {
+	host_name := snapshot.Hostname
+	for _, process := range snapshot.Processes {
+		// Like iotop --only, throughput is only reported for processes that did I/O since the previous collection
+		rate := process.IORate
+		if !process.HasIORate || (rate.ReadBytesPerSec == 0 && rate.WriteBytesPerSec == 0) {
+			continue
+		}
+		read_Ks := strconv.FormatFloat(rate.ReadBytesPerSec/1024, 'f', 2, 64)
+		write_Ks := strconv.FormatFloat(rate.WriteBytesPerSec/1024, 'f', 2, 64)
+		labels := []string{host_name, strconv.Itoa(process.PID), process.User, read_Ks, write_Ks, process.ContainerName, process.ContainerID, process.Command()}
+		sendMetric(ch, legacyReadKBDesc, prometheus.GaugeValue, rate.ReadBytesPerSec/1024, labels...)
+		sendMetric(ch, legacyWriteKBDesc, prometheus.GaugeValue, rate.WriteBytesPerSec/1024, labels...)
+	}
+	for _, process := range snapshot.Processes {
+		process_command_str := process.Command()
+		// vsz and rss are reported in KiB like ps does
+		cpu_percent := strconv.FormatFloat(process.CPUPercent, 'f', 1, 64)
+		vsz := strconv.FormatUint(process.VSZ/1024, 10)
+		rss := strconv.FormatUint(process.RSS/1024, 10)
+		labels := []string{host_name, process.User, strconv.Itoa(process.PID), cpu_percent, vsz, rss, process.ContainerName, process.ContainerID, process_command_str}
+		sendMetric(ch, legacyCPUPercentDesc, prometheus.GaugeValue, process.CPUPercent, labels...)
+		sendMetric(ch, legacyVSZDesc, prometheus.GaugeValue, float64(process.VSZ/1024), labels...)
+		sendMetric(ch, legacyRSSDesc, prometheus.GaugeValue, float64(process.RSS/1024), labels...)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The logind series cover every session logind tracks, including those without a terminal
+// that utmp never sees, such as sftp and VS Code remote sessions.
+var (
+	logindSessionLabels    = []string{"hostname", "session", "user", "seat", "tty", "remote_host", "service", "class", "type", "state"}
+	logindSessionInfoDesc  = prometheus.NewDesc("logind_session_info", "Session tracked by systemd-logind, always 1.", logindSessionLabels, nil)
+	logindSessionStartDesc = prometheus.NewDesc("logind_session_start_time_seconds", "Time the logind session was opened, in seconds since the epoch.", []string{"hostname", "session", "user"}, nil)
+	logindSessionIdleDesc  = prometheus.NewDesc("logind_session_idle_since_seconds", "Time the logind session became idle, in seconds since the epoch. Only present while logind considers the session idle.", []string{"hostname", "session", "user"}, nil)
+	logindSessionsDesc     = prometheus.NewDesc("logind_sessions", "Number of logind sessions by class, type and state.", []string{"hostname", "class", "type", "state"}, nil)
+	logindSeatsDesc        = prometheus.NewDesc("logind_seats", "Number of seats known to systemd-logind.", []string{"hostname"}, nil)
+	logindUserInfoDesc     = prometheus.NewDesc("logind_user_info", "User known to systemd-logind, always 1.", []string{"hostname", "user", "uid", "state", "linger"}, nil)
+	logindDescs            = []*prometheus.Desc{logindSessionInfoDesc, logindSessionStartDesc, logindSessionIdleDesc, logindSessionsDesc, logindSeatsDesc, logindUserInfoDesc}
+)
+
+type logindSessionKey struct {
+	class string
+	kind  string
+	state string
+}
+
+func collectLogind(ch chan<- prometheus.Metric, snapshot *Snapshot) {
+	state := snapshot.Logind
+	if state == nil {
+		return
+	}
+	counts := map[logindSessionKey]int{}
+	for _, s := range state.Sessions {
+		sendMetric(ch, logindSessionInfoDesc, prometheus.GaugeValue, 1,
+			snapshot.Hostname, s.ID, s.User, s.Seat, s.TTY, s.RemoteHost, s.Service, s.Class, s.Type, s.State)
+		if !s.Started.IsZero() {
+			sendMetric(ch, logindSessionStartDesc, prometheus.GaugeValue, float64(s.Started.UnixMicro())/1e6, snapshot.Hostname, s.ID, s.User)
+		}
+		if s.IdleHint && !s.IdleSince.IsZero() {
+			sendMetric(ch, logindSessionIdleDesc, prometheus.GaugeValue, float64(s.IdleSince.UnixMicro())/1e6, snapshot.Hostname, s.ID, s.User)
+		}
+		counts[logindSessionKey{class: s.Class, kind: s.Type, state: s.State}]++
+	}
+	for key, n := range counts {
+		sendMetric(ch, logindSessionsDesc, prometheus.GaugeValue, float64(n), snapshot.Hostname, key.class, key.kind, key.state)
+	}
+	sendMetric(ch, logindSeatsDesc, prometheus.GaugeValue, float64(len(state.Seats)), snapshot.Hostname)
+	for _, u := range state.Users {
+		sendMetric(ch, logindUserInfoDesc, prometheus.GaugeValue, 1,
+			snapshot.Hostname, u.Name, strconv.FormatUint(uint64(u.UID), 10), u.State, strconv.FormatBool(u.Linger))
+	}
+}
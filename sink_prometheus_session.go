@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The session lifecycle series count what happened between scrapes, which logged_in_user
+// cannot show. kind and site are those of logged_in_user.
+var (
+	loginLabels          = []string{"hostname", "user", "kind", "site"}
+	sessionLoginsDesc    = prometheus.NewDesc("session_logins_total", "Logins seen since the exporter started.", loginLabels, nil)
+	sessionLogoutsDesc   = prometheus.NewDesc("session_logouts_total", "Logouts seen since the exporter started.", loginLabels, nil)
+	sessionDurationDesc  = prometheus.NewDesc("session_duration_seconds", "Duration of the sessions that ended since the exporter started.", userLabels, nil)
+	userLastLoginDesc    = prometheus.NewDesc("user_last_login_timestamp_seconds", "Time of the most recent login of the user.", userLabels, nil)
+	failedLoginsDesc     = prometheus.NewDesc("failed_logins_total", "Failed login attempts seen since the exporter started. user is invalid for names that do not exist on the host.", []string{"hostname", "user", "from", "method"}, nil)
+	policyViolationsDesc = prometheus.NewDesc("policy_violations", "Sessions of the user that break the rule, 0 when the user keeps it.", []string{"hostname", "rule", "user"}, nil)
+	sessionDescs         = []*prometheus.Desc{sessionLoginsDesc, sessionLogoutsDesc, sessionDurationDesc, userLastLoginDesc, failedLoginsDesc, policyViolationsDesc}
+)
+
+func collectSessionLifecycle(ch chan<- prometheus.Metric, snapshot *Snapshot) {
+	for _, c := range snapshot.Lifecycle.Logins {
+		labels := []string{snapshot.Hostname, c.User, c.Kind, c.Site}
+		sendMetric(ch, sessionLoginsDesc, prometheus.CounterValue, float64(c.Logins), labels...)
+		sendMetric(ch, sessionLogoutsDesc, prometheus.CounterValue, float64(c.Logouts), labels...)
+	}
+	for _, h := range snapshot.Lifecycle.Users {
+		labels := []string{snapshot.Hostname, strings.ToValidUTF8(h.User, "�")}
+		histogram, err := prometheus.NewConstHistogram(sessionDurationDesc, h.Ended, h.DurationSeconds, h.Buckets, labels...)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(sessionDurationDesc, err)
+		} else {
+			ch <- histogram
+		}
+		if !h.LastLogin.IsZero() {
+			sendMetric(ch, userLastLoginDesc, prometheus.GaugeValue, float64(h.LastLogin.UnixNano())/1e9, labels...)
+		}
+	}
+	for _, f := range snapshot.FailedLogins {
+		sendMetric(ch, failedLoginsDesc, prometheus.CounterValue, float64(f.Count), snapshot.Hostname, f.User, f.From, f.Method)
+	}
+}
+
+func collectPolicies(ch chan<- prometheus.Metric, snapshot *Snapshot) {
+	for _, v := range snapshot.Violations {
+		sendMetric(ch, policyViolationsDesc, prometheus.GaugeValue, float64(v.Sessions), snapshot.Hostname, v.Rule, v.User)
+	}
+}
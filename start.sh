@@ -3,8 +3,6 @@ FULL_PATH=`realpath $0`
 TARGET_DIR=`dirname $FULL_PATH`
 PORT=49996
 cd $TARGET_DIR
-export PROCPS_USERLEN=20
-export LC_ALL=C
 export OS=$(uname | tr '[:upper:]' '[:lower:]')
 export ARCH=$(uname -m)
 if [ "$ARCH" == "x86_64" ]; then
@@ -14,4 +12,9 @@ if [ "$ARCH" == "aarch64" ]; then
   export ARCH="arm64"
 fi
 cp prometheus-exporter-logged-users-$OS-$ARCH prometheus-exporter-logged-users
-./prometheus-exporter-logged-users --port $PORT
\ No newline at end of file
+CONFIG=$TARGET_DIR/config.yaml
+if [ -f "$CONFIG" ]; then
+  # exec keeps the PID of the service, so systemctl reload can send SIGHUP to the exporter
+  exec ./prometheus-exporter-logged-users --config "$CONFIG"
+fi
+exec ./prometheus-exporter-logged-users --port $PORT
\ No newline at end of file
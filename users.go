@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// UserUsage is the combined resource usage of the processes and sessions of one user.
+// CPUSeconds, IOReadBytes and IOWriteBytes keep what exited processes used, so they only go up.
+type UserUsage struct {
+	User       string
+	Processes  int
+	CPUSeconds float64
+	RSS        uint64
+	// IOReadBytes and IOWriteBytes only include the processes whose I/O counters could be read.
+	IOReadBytes  uint64
+	IOWriteBytes uint64
+	Sessions     int
+	// StaleSessions are the sessions idle for longer than sessions.stale_after.
+	StaleSessions int
+}
+
+// aggregateUsers sums the processes and sessions of a snapshot by user, sorted by user name.
+// Unlike the per-process series every process counts, whatever its command. The CPU and I/O
+// counters continue from the totals in counters. Sessions idle for longer than staleAfter
+// are counted as stale, none when it is 0.
+func aggregateUsers(processes []ProcessSample, sessions []Session, staleAfter time.Duration, counters *usageCounterSet[string]) []UserUsage {
+	byUser := map[string]*UserUsage{}
+	get := func(user string) *UserUsage {
+		usage, ok := byUser[user]
+		if !ok {
+			usage = &UserUsage{User: user}
+			byUser[user] = usage
+		}
+		return usage
+	}
+	counters.begin()
+	for i := range processes {
+		process := &processes[i]
+		usage := get(process.User)
+		usage.Processes++
+		usage.RSS += process.RSS
+		counters.add(process.User, &process.Process, process.Delta)
+	}
+	for _, session := range sessions {
+		usage := get(session.User)
+		usage.Sessions++
+		if staleAfter > 0 && session.HasIdle && session.Idle > staleAfter {
+			usage.StaleSessions++
+		}
+	}
+
+	users := make([]UserUsage, 0, len(byUser))
+	for _, usage := range byUser {
+		total := counters.get(usage.User)
+		usage.CPUSeconds = total.CPUSeconds
+		usage.IOReadBytes = total.IO.ReadBytes
+		usage.IOWriteBytes = total.IO.WriteBytes
+		users = append(users, *usage)
+	}
+	counters.end()
+	sort.Slice(users, func(i, j int) bool { return users[i].User < users[j].User })
+	return users
+}
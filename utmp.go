@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const defaultUtmpPath = "/var/run/utmp"
+
+// utmp record types from <utmp.h>
+const (
+	utEmpty        = 0
+	utRunLevel     = 1
+	utBootTime     = 2
+	utNewTime      = 3
+	utOldTime      = 4
+	utInitProcess  = 5
+	utLoginProcess = 6
+	utUserProcess  = 7
+	utDeadProcess  = 8
+)
+
+// utmpRecord mirrors the on-disk layout of struct utmp on Linux (glibc, 384 bytes).
+// The same layout is used on both 32-bit and 64-bit platforms.
+type utmpRecord struct {
+	Type    int16
+	_       [2]byte
+	Pid     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	Sec     int32
+	Usec    int32
+	AddrV6  [16]byte
+	_       [20]byte
+}
+
+var utmpRecordSize = binary.Size(utmpRecord{})
+
+// Session is a single login session as recorded in utmp.
+type Session struct {
+	Type      int
+	User      string
+	TTY       string
+	Host      string
+	LoginTime time.Time
+	PID       int
+	SessionID int
+	Addr      net.IP
+	// Idle is only valid when HasIdle is set, see ttyIdle.
+	Idle    time.Duration
+	HasIdle bool
+	// Kind, Address, RemoteHost and Site are filled in by sessionEnricher.
+	Kind       string
+	Address    string
+	RemoteHost string
+	Site       string
+	// Usage is only valid when HasUsage is set, i.e. processes were collected, see attributeSessions.
+	Usage    SessionUsage
+	HasUsage bool
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func (r *utmpRecord) addr() net.IP {
+	// ut_addr_v6 is stored in network byte order; IPv4 addresses only use the first word
+	if r.AddrV6 == [16]byte{} {
+		return nil
+	}
+	if bytes.Count(r.AddrV6[4:], []byte{0}) == 12 {
+		// An IPv6 address ending in 12 zero bytes looks the same, unless the host is that address
+		if host := net.ParseIP(cString(r.Host[:])); host == nil || host.To4() != nil {
+			return net.IPv4(r.AddrV6[0], r.AddrV6[1], r.AddrV6[2], r.AddrV6[3])
+		}
+	}
+	return net.IP(bytes.Clone(r.AddrV6[:]))
+}
+
+func (r *utmpRecord) session() Session {
+	return Session{
+		Type:      int(r.Type),
+		User:      cString(r.User[:]),
+		TTY:       cString(r.Line[:]),
+		Host:      cString(r.Host[:]),
+		LoginTime: time.Unix(int64(r.Sec), int64(r.Usec)*int64(time.Microsecond)),
+		PID:       int(r.Pid),
+		SessionID: int(r.Session),
+		Addr:      r.addr(),
+	}
+}
+
+// readUtmpRecords decodes every record in a utmp-formatted stream (utmp, wtmp or btmp).
+// A truncated trailing record is ignored.
+func readUtmpRecords(r io.Reader) ([]Session, error) {
+	var sessions []Session
+	buf := make([]byte, utmpRecordSize)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return sessions, nil
+			}
+			return sessions, err
+		}
+		var rec utmpRecord
+		if err := binary.Read(bytes.NewReader(buf), binary.NativeEndian, &rec); err != nil {
+			return sessions, err
+		}
+		sessions = append(sessions, rec.session())
+	}
+}
+
+func readUtmpFile(path string) ([]Session, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open utmp file: %w", err)
+	}
+	defer file.Close()
+
+	sessions, err := readUtmpRecords(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading utmp file %s: %w", path, err)
+	}
+	return sessions, nil
+}
+
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(procRoot, strconv.Itoa(pid)))
+	return err == nil
+}
+
+// getLoggedInUsers returns the active user sessions from utmp, skipping stale
+// entries whose session leader no longer exists. A missing utmp file, as on
+// minimal container images, means nobody is logged in.
+func getLoggedInUsers() ([]Session, error) {
+	records, err := readUtmpFile(defaultUtmpPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sessions := make([]Session, 0, len(records))
+	for _, s := range records {
+		if s.Type != utUserProcess || s.User == "" {
+			continue
+		}
+		if !processExists(s.PID) {
+			continue
+		}
+		s.Idle, s.HasIdle = ttyIdle(s.TTY, now)
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
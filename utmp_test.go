@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testUtmpRecord(kind int16, pid int32, line, user, host string, login time.Time, addr net.IP) utmpRecord {
+	r := utmpRecord{Type: kind, Pid: pid, Session: pid, Sec: int32(login.Unix()), Usec: int32(login.Nanosecond() / 1000)}
+	copy(r.Line[:], line)
+	copy(r.User[:], user)
+	copy(r.Host[:], host)
+	if v4 := addr.To4(); v4 != nil {
+		copy(r.AddrV6[:], v4)
+	} else {
+		copy(r.AddrV6[:], addr)
+	}
+	return r
+}
+
+func writeUtmpRecords(t *testing.T, records ...utmpRecord) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, r := range records {
+		if err := binary.Write(&buf, binary.NativeEndian, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestUtmpRecordSize(t *testing.T) {
+	if utmpRecordSize != 384 {
+		t.Fatalf("utmpRecordSize = %d, want the 384 bytes of glibc's struct utmp", utmpRecordSize)
+	}
+}
+
+func TestReadUtmpRecords(t *testing.T) {
+	login := time.Date(2024, 5, 1, 8, 30, 15, 250000000, time.UTC)
+	data := writeUtmpRecords(t,
+		testUtmpRecord(utBootTime, 0, "~", "reboot", "6.8.0-31-generic", login.Add(-time.Hour), nil),
+		testUtmpRecord(utUserProcess, 812, "tty1", "alice", "", login, nil),
+		testUtmpRecord(utUserProcess, 4242, "pts/0", "bob", "203.0.113.7", login, net.ParseIP("203.0.113.7")),
+		testUtmpRecord(utUserProcess, 4243, "pts/1", "carol", "2001:db8::7", login, net.ParseIP("2001:db8::7")),
+		// IPv6 addresses with zeros in the last 12 bytes must not be taken for IPv4
+		testUtmpRecord(utUserProcess, 4244, "pts/2", "dave", "2001:db8::", login, net.ParseIP("2001:db8::")),
+		testUtmpRecord(utDeadProcess, 4242, "pts/0", "", "", login.Add(time.Minute), nil),
+	)
+	sessions, err := readUtmpRecords(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 6 {
+		t.Fatalf("got %d records, want 6", len(sessions))
+	}
+	tests := []struct {
+		kind      int
+		user, tty string
+		host      string
+		pid       int
+		addr      string
+	}{
+		{utBootTime, "reboot", "~", "6.8.0-31-generic", 0, ""},
+		{utUserProcess, "alice", "tty1", "", 812, ""},
+		{utUserProcess, "bob", "pts/0", "203.0.113.7", 4242, "203.0.113.7"},
+		{utUserProcess, "carol", "pts/1", "2001:db8::7", 4243, "2001:db8::7"},
+		{utUserProcess, "dave", "pts/2", "2001:db8::", 4244, "2001:db8::"},
+		{utDeadProcess, "", "pts/0", "", 4242, ""},
+	}
+	for i, want := range tests {
+		s := sessions[i]
+		if s.Type != want.kind || s.User != want.user || s.TTY != want.tty || s.Host != want.host || s.PID != want.pid || s.SessionID != want.pid {
+			t.Errorf("record %d = %+v, want %+v", i, s, want)
+		}
+		if addr := s.Addr.String(); (s.Addr != nil || want.addr != "") && addr != want.addr {
+			t.Errorf("record %d: Addr = %s, want %q", i, addr, want.addr)
+		}
+		if want.addr != "" && net.ParseIP(want.addr).To4() != nil && len(s.Addr.To4()) != net.IPv4len {
+			t.Errorf("record %d: Addr %s is not IPv4", i, s.Addr)
+		}
+	}
+	if !sessions[1].LoginTime.Equal(login) {
+		t.Errorf("LoginTime = %s, want %s", sessions[1].LoginTime, login)
+	}
+}
+
+func TestReadUtmpRecordsTruncated(t *testing.T) {
+	login := time.Unix(1714552215, 0)
+	data := writeUtmpRecords(t,
+		testUtmpRecord(utUserProcess, 812, "tty1", "alice", "", login, nil),
+		testUtmpRecord(utUserProcess, 813, "tty2", "bob", "", login, nil),
+	)
+	// The second record is still being written
+	sessions, err := readUtmpRecords(bytes.NewReader(data[:utmpRecordSize+100]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].User != "alice" {
+		t.Fatalf("got %+v, want only the complete record of alice", sessions)
+	}
+
+	path := filepath.Join(t.TempDir(), "utmp")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, err := readUtmpFile(path); err != nil || len(sessions) != 2 {
+		t.Fatalf("readUtmpFile = %d records, %v", len(sessions), err)
+	}
+	if _, err := readUtmpFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing file: no error")
+	}
+}